@@ -0,0 +1,40 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+)
+
+// checkConfig validates configuration merged from given files and reports every problem found.
+// Returned value is suitable as process exit code.
+func checkConfig(out io.Writer, files []string) int {
+	if len(files) == 0 {
+		files = *configFiles
+	}
+	if _, err := internal.LoadConfig(files...); err != nil {
+		// joined errors are reported one per line
+		_, _ = fmt.Fprintln(out, err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(out, "%s: OK\n", strings.Join(files, ", "))
+	return 0
+}
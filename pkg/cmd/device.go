@@ -0,0 +1,230 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/rkosegi/tuya-proto/proto"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+)
+
+// deviceFlags select single device, either configured one or one given by flags.
+type deviceFlags struct {
+	name     *string
+	address  *string
+	id       *string
+	key      *string
+	protocol *string
+}
+
+func addDeviceFlags(cmd *kingpin.CmdClause) *deviceFlags {
+	return &deviceFlags{
+		name:     cmd.Arg("device", "Name of configured device, or address of device which is not configured.").Required().String(),
+		address:  cmd.Flag("address", "Address of device, overrides configured one.").String(),
+		id:       cmd.Flag("id", "Id of device, overrides configured one.").String(),
+		key:      cmd.Flag("key", "Local key of device, overrides configured one.").Envar("TUYA_DEVICE_KEY").String(),
+		protocol: cmd.Flag("protocol", "Protocol of device, overrides configured one.").String(),
+	}
+}
+
+// spec returns connection settings of selected device, with defaults applied.
+// Device which is not configured requires id and key to be given, configuration file may be missing then.
+func (f *deviceFlags) spec() (internal.DeviceConnectionSpec, error) {
+	cfg, err := internal.LoadConfig(*configFiles...)
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist) && *f.id != "" && *f.key != "":
+		cfg = &internal.ConfigSpec{}
+	default:
+		return internal.DeviceConnectionSpec{}, err
+	}
+	name := *f.name
+	dc, ok := cfg.Devices[name]
+	if !ok {
+		if *f.id == "" || *f.key == "" {
+			return dc, fmt.Errorf("unknown device '%s', --id and --key are required for device which is not configured", name)
+		}
+		dc = internal.DeviceConnectionSpec{Address: name}
+	}
+	if ok && *f.address == "" && *f.id == "" && *f.key == "" && *f.protocol == "" {
+		// configured device already has defaults applied and is validated
+		return dc, nil
+	}
+	dc.Address = lo.CoalesceOrEmpty(*f.address, dc.Address)
+	dc.Id = lo.CoalesceOrEmpty(*f.id, dc.Id)
+	dc.Key = lo.CoalesceOrEmpty(*f.key, dc.Key)
+	dc.Protocol = lo.CoalesceOrEmpty(*f.protocol, dc.Protocol)
+	// device is validated against rest of configuration, such as extra labels and discovery
+	tmp := *cfg
+	tmp.Devices = internal.DevicesContainer{name: dc}
+	tmp.ApplyDefaults()
+	if !ok {
+		// extra labels don't apply to device that is not configured
+		dc = tmp.Devices[name]
+		dc.ExtraLabels = nil
+		tmp.Devices[name] = dc
+		tmp.ExtraDeviceLabels = nil
+		tmp.ExtraDeviceLabelsMapping = nil
+	}
+	if err = tmp.Validate(); err != nil {
+		return dc, err
+	}
+	return tmp.Devices[name], nil
+}
+
+// client returns spec of selected device along with its client.
+func (f *deviceFlags) client(l *slog.Logger) (internal.DeviceConnectionSpec, internal.Client, error) {
+	dc, err := f.spec()
+	if err != nil {
+		return dc, nil, err
+	}
+	return dc, internal.NewDeviceClient(dc, internal.WithLogger(l.With("address", dc.Address, "protocol", dc.Protocol))), nil
+}
+
+func printJson(out io.Writer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// queryDevice prints current value of all data points of device.
+// Returned value is suitable as process exit code.
+func queryDevice(f *deviceFlags, out io.Writer, l *slog.Logger) int {
+	dc, cl, err := f.client(l)
+	if err != nil {
+		l.Error("Invalid device", "err", err)
+		return 1
+	}
+	status, err := internal.QueryDps(cl, dc)
+	if err != nil {
+		l.Error("Unable to query device", "device", *f.name, "reason", internal.ErrorReason(err), "err", err)
+		return 1
+	}
+	_ = printJson(out, status.Dps)
+	return 0
+}
+
+// parseDps parses data point assignments in form of <dp>=<value>.
+// Value is parsed as JSON, so that booleans and numbers are sent as such, other values are sent as strings.
+func parseDps(args []string) (map[string]any, error) {
+	dps := map[string]any{}
+	for _, arg := range args {
+		dp, value, ok := strings.Cut(arg, "=")
+		if !ok || dp == "" {
+			return nil, fmt.Errorf("invalid data point '%s', expected <dp>=<value>", arg)
+		}
+		var v any
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			v = value
+		}
+		dps[dp] = v
+	}
+	return dps, nil
+}
+
+// setDevice changes value of data points of device.
+// Returned value is suitable as process exit code.
+func setDevice(f *deviceFlags, args []string, l *slog.Logger) int {
+	dps, err := parseDps(args)
+	if err != nil {
+		l.Error("Invalid arguments", "err", err)
+		return 1
+	}
+	dc, cl, err := f.client(l)
+	if err != nil {
+		l.Error("Invalid device", "err", err)
+		return 1
+	}
+	if err = internal.SetDps(cl, dc, dps); err != nil {
+		l.Error("Unable to control device", "device", *f.name, "reason", internal.ErrorReason(err), "err", err)
+		return 1
+	}
+	l.Info("Data points set", "device", *f.name, "dps", dps)
+	return 0
+}
+
+// watchDevice prints current status of device and then every status pushed by device, one JSON object per line.
+// It runs until interrupted, or until connection fails. Returned value is suitable as process exit code.
+func watchDevice(f *deviceFlags, out io.Writer, l *slog.Logger) int {
+	dc, cl, err := f.client(l)
+	if err != nil {
+		l.Error("Invalid device", "err", err)
+		return 1
+	}
+	enc := json.NewEncoder(out)
+	err = internal.WatchDps(context.Background(), cl, dc, func(status *internal.DpQueryResponse) {
+		_ = enc.Encode(map[string]any{
+			"time": time.Now().Format(time.RFC3339Nano),
+			"dps":  status.Dps,
+		})
+	})
+	if err != nil {
+		l.Error("Connection to device failed", "device", *f.name, "reason", internal.ErrorReason(err), "err", err)
+		return 1
+	}
+	return 0
+}
+
+// rawDevice sends command with given payload to device and prints its response.
+// Returned value is suitable as process exit code.
+func rawDevice(f *deviceFlags, cmd uint32, payload string, out io.Writer, l *slog.Logger) int {
+	_, cl, err := f.client(l)
+	if err != nil {
+		l.Error("Invalid device", "err", err)
+		return 1
+	}
+	if err = cl.Connect(); err != nil {
+		l.Error("Unable to connect to device", "device", *f.name, "reason", internal.ErrorReason(err), "err", err)
+		return 1
+	}
+	defer func() {
+		_ = cl.Close()
+	}()
+	if err = cl.Send(proto.CmdIdType(cmd), payload); err != nil {
+		l.Error("Unable to send command", "device", *f.name, "err", err)
+		return 1
+	}
+	var resp json.RawMessage
+	if err = cl.Read(&resp); err != nil {
+		if errors.Is(err, internal.ErrShortPayload) {
+			l.Info("Device responded with empty payload", "device", *f.name)
+			return 0
+		}
+		l.Error("Unable to read response", "device", *f.name, "reason", internal.ErrorReason(err), "err", err)
+		return 1
+	}
+	var buf bytes.Buffer
+	if json.Indent(&buf, resp, "", "  ") != nil {
+		buf.Reset()
+		buf.Write(resp)
+	}
+	buf.WriteByte('\n')
+	_, _ = out.Write(buf.Bytes())
+	return 0
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+const deviceTestConfig = `
+defaults:
+  protocol: tuya3.4
+  extraLabels:
+    floor: ground
+devices:
+  kitchen:
+    id: id1
+    key: 0123456789abcdef
+    address: 192.168.1.5
+    extraLabels:
+      room: kitchen
+extraDeviceLabels:
+  - room
+  - floor
+extraDeviceLabelsMapping:
+  room: location
+discovery: {}
+`
+
+func testDeviceFlags(name string) *deviceFlags {
+	return &deviceFlags{name: &name, address: new(""), id: new(""), key: new(""), protocol: new("")}
+}
+
+func TestDeviceFlagsSpec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(deviceTestConfig), 0o600))
+	saved := *configFiles
+	defer func() {
+		*configFiles = saved
+	}()
+	*configFiles = []string{path}
+
+	// configured device with extra labels
+	dc, err := testDeviceFlags("kitchen").spec()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.5", dc.Address)
+	assert.Equal(t, "tuya3.4", dc.Protocol)
+	assert.Equal(t, internal.DefaultProfile, dc.Profile)
+	assert.Equal(t, map[string]string{"room": "kitchen", "floor": "ground"}, *dc.ExtraLabels)
+
+	// configured device with overrides
+	f := testDeviceFlags("kitchen")
+	*f.address = "192.168.1.6"
+	*f.protocol = "tuya3.1"
+	dc, err = f.spec()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.6", dc.Address)
+	assert.Equal(t, "tuya3.1", dc.Protocol)
+	assert.Equal(t, "0123456789abcdef", dc.Key)
+	assert.Equal(t, map[string]string{"room": "kitchen", "floor": "ground"}, *dc.ExtraLabels)
+
+	// device which is not configured gets defaults of configuration, but no extra labels
+	f = testDeviceFlags("192.168.1.7")
+	*f.id, *f.key = "id2", "fedcba9876543210"
+	dc, err = f.spec()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.7", dc.Address)
+	assert.Equal(t, "tuya3.4", dc.Protocol)
+	assert.Nil(t, dc.ExtraLabels)
+
+	_, err = testDeviceFlags("192.168.1.7").spec()
+	assert.ErrorContains(t, err, "--id and --key are required")
+
+	f = testDeviceFlags("kitchen")
+	*f.protocol = "9.9"
+	_, err = f.spec()
+	assert.ErrorContains(t, err, "unknown protocol '9.9'")
+}
+
+func TestDeviceFlagsSpecConfigError(t *testing.T) {
+	dir := t.TempDir()
+	saved := *configFiles
+	defer func() {
+		*configFiles = saved
+	}()
+
+	// configuration is optional for device which is not configured
+	*configFiles = []string{filepath.Join(dir, "missing.yaml")}
+	f := testDeviceFlags("192.168.1.7")
+	*f.id, *f.key = "id2", "fedcba9876543210"
+	dc, err := f.spec()
+	assert.NoError(t, err)
+	assert.Equal(t, internal.DefaultProtocol, dc.Protocol)
+
+	// but it must be valid when present
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("devices: []\n"), 0o600))
+	*configFiles = []string{path}
+	_, err = f.spec()
+	assert.Error(t, err)
+}
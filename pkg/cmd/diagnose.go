@@ -0,0 +1,71 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+)
+
+// diagnoseDevice walks through connectivity to device step by step and prints report, either as text or as JSON.
+// Returned value is suitable as process exit code.
+func diagnoseDevice(f *deviceFlags, format string, out io.Writer, l *slog.Logger) int {
+	dc, err := f.spec()
+	if err != nil {
+		l.Error("Invalid device", "err", err)
+		return 1
+	}
+	d := internal.Diagnose(context.Background(), dc, l)
+	if format == "json" {
+		_ = printJson(out, d)
+	} else {
+		printDiagnosis(out, *f.name, d)
+	}
+	if !d.Ok {
+		return 1
+	}
+	return 0
+}
+
+func printDiagnosis(out io.Writer, name string, d *internal.Diagnosis) {
+	_, _ = fmt.Fprintf(out, "Device %s at %s, protocol %s\n\n", name, d.Address, d.Protocol)
+	width := 0
+	for _, s := range d.Steps {
+		width = max(width, len(s.Name))
+	}
+	for _, s := range d.Steps {
+		took := ""
+		if s.Status != internal.StepSkipped {
+			took = time.Duration(s.DurationMs * float64(time.Millisecond)).Round(100 * time.Microsecond).String()
+		}
+		line := fmt.Sprintf("%-*s  %-7s  %8s  %s", width, s.Name, strings.ToUpper(s.Status), took, s.Detail)
+		_, _ = fmt.Fprintln(out, strings.TrimRight(line, " "))
+		indent := strings.Repeat(" ", width+2)
+		if s.Error != "" {
+			_, _ = fmt.Fprintf(out, "%serror: %s\n", indent, s.Error)
+		}
+		if s.Hint != "" {
+			_, _ = fmt.Fprintf(out, "%shint:  %s\n", indent, s.Hint)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"gopkg.in/yaml.v3"
+)
+
+// discoverDevices listens for device broadcasts for given duration and prints devices found in configuration format.
+// Returned value is suitable as process exit code.
+func discoverDevices(duration time.Duration, addrs []string, out io.Writer, l *slog.Logger) int {
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	d := internal.NewDiscovery(l)
+	l.Info("Listening for device broadcasts", "duration", duration, "addresses", addrs)
+	if err := d.Listen(ctx, addrs...); err != nil {
+		l.Error("Unable to listen for device broadcasts", "err", err)
+		return 1
+	}
+	devs := d.Devices()
+	l.Info("Discovery finished", "devices", len(devs))
+	if len(devs) == 0 {
+		return 0
+	}
+	data, err := discoveredConfig(devs)
+	if err != nil {
+		l.Error("Unable to format devices", "err", err)
+		return 1
+	}
+	_, _ = out.Write(data)
+	return 0
+}
+
+// discoveredConfig formats discovered devices as devices section of configuration.
+// Devices are named by their id, since broadcast carries no name.
+func discoveredConfig(devs []internal.DiscoveredDevice) ([]byte, error) {
+	str := func(v string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+	}
+	devices := &yaml.Node{Kind: yaml.MappingNode}
+	for _, dev := range devs {
+		spec := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+			str("id"), str(dev.Id),
+			str("address"), str(dev.Ip),
+		}}
+		name := str(dev.Id)
+		if p := dev.Protocol(); p != "" {
+			spec.Content = append(spec.Content, str("protocol"), str(p))
+			name.HeadComment = fmt.Sprintf("product key %s, local key must be added", dev.ProductKey)
+		} else {
+			name.HeadComment = fmt.Sprintf("product key %s, protocol version %s is not supported", dev.ProductKey, dev.Version)
+		}
+		devices.Content = append(devices.Content, name, spec)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{str("devices"), devices}}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+)
+
+// importDevices reads device list from source ("-" for stdin) and merges it into configuration file output.
+// When output is empty, resulting configuration is written to stdout.
+// Returned value is suitable as process exit code.
+func importDevices(source, output string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fail := func(err error) int {
+		_, _ = fmt.Fprintln(stderr, "import failed:", err)
+		return 1
+	}
+	var (
+		data []byte
+		err  error
+	)
+	// kingpin turns lone "-" into empty argument
+	if source == "-" || source == "" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	devs, err := internal.ParseImportedDevices(data)
+	if err != nil {
+		return fail(err)
+	}
+	var doc []byte
+	if output != "" {
+		if doc, err = os.ReadFile(output); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fail(err)
+		}
+	}
+	doc, res, err := internal.MergeImportedDevices(doc, devs)
+	if err != nil {
+		return fail(err)
+	}
+	if output == "" {
+		_, _ = stdout.Write(doc)
+	} else if err = writeFileAtomic(output, doc); err != nil {
+		return fail(err)
+	}
+	for _, line := range []struct {
+		what  string
+		names []string
+	}{
+		{"added", res.Added},
+		{"updated", res.Updated},
+		{"skipped", res.Skipped},
+		{"address missing", res.MissingAddress},
+		{"key kept", res.KeptKeys},
+	} {
+		if len(line.names) > 0 {
+			_, _ = fmt.Fprintf(stderr, "%s: %s\n", line.what, strings.Join(line.names, ", "))
+		}
+	}
+	return 0
+}
+
+// writeFileAtomic writes data to temporary file in the same directory and renames it to target,
+// so that exporter watching the file never sees it partially written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		if err = os.Chmod(f.Name(), fi.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.Name(), path)
+}
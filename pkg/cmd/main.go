@@ -17,16 +17,20 @@ limitations under the License.
 package main
 
 import (
-	"errors"
+	"context"
+	"maps"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/rkosegi/tuya-smartplug-exporter/pkg/exporter"
 	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
-	"gopkg.in/yaml.v3"
+	"github.com/samber/lo"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -46,9 +50,40 @@ const (
 var (
 	webConfig             = webflag.AddFlags(kingpin.CommandLine, ":9999")
 	telemetryPath         = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-	configFile            = kingpin.Flag("config.file", "Path to YAML file with configuration").Default("config.yaml").String()
+	configFiles           = kingpin.Flag("config.file", "Path to YAML file with configuration or to directory with configuration fragments. Can be repeated.").Default("config.yaml").Strings()
+	configWatchInterval   = kingpin.Flag("config.watch-interval", "How often to check configuration file for changes. Set to 0 to disable.").Default("0s").Duration()
 	disableDefaultMetrics = kingpin.Flag("disable-default-metrics", "Exclude default metrics about the exporter itself (promhttp_*, process_*, go_*).").Bool()
-	errNoDevs             = errors.New("no devices configured")
+	textfile              = kingpin.Flag("output.textfile", "Write metrics to given file for textfile collector of node_exporter instead of serving them over HTTP.").String()
+	textfileInterval      = kingpin.Flag("output.textfile-interval", "How often to write metrics to textfile. When 0, devices are queried once and exporter exits.").Default("0s").Duration()
+
+	serveCmd         = kingpin.Command("serve", "Run the exporter (default).").Default()
+	checkConfigCmd   = kingpin.Command("check-config", "Validate configuration and exit. Given files and directories are merged into single configuration.")
+	checkConfigFiles = checkConfigCmd.Arg("file", "Configuration file(s) or directories to validate, value of --config.file is used if none given.").Strings()
+	importCmd        = kingpin.Command("import", "Import devices from tinytuya devices.json or tuya-cli wizard output.")
+	importSource     = importCmd.Arg("source", "File with list of devices, '-' for standard input.").Required().String()
+	importOutput     = importCmd.Flag("output", "Configuration file to merge devices into, it is created if missing. Result is written to standard output if not set.").Short('o').String()
+	discoverCmd      = kingpin.Command("discover", "Listen for device broadcasts on local network and print devices found in configuration format.")
+	discoverDuration = discoverCmd.Flag("duration", "How long to listen for broadcasts.").Default("10s").Duration()
+	discoverListen   = discoverCmd.Flag("listen", "UDP address to listen on, can be repeated.").Default(internal.DiscoveryAddrs...).Strings()
+	queryCmd         = kingpin.Command("query", "Query device and print current value of its data points.")
+	queryDev         = addDeviceFlags(queryCmd)
+	setCmd           = kingpin.Command("set", "Change value of data points of device.")
+	setDev           = addDeviceFlags(setCmd)
+	setDps           = setCmd.Arg("dps", "Data points to set, in form of <dp>=<value>, such as 1=true.").Required().Strings()
+	watchCmd         = kingpin.Command("watch", "Print status of device whenever it changes, until interrupted.")
+	watchDev         = addDeviceFlags(watchCmd)
+	rawCmd           = kingpin.Command("raw", "Send command with arbitrary payload to device and print its response.")
+	rawDev           = addDeviceFlags(rawCmd)
+	rawCmdId         = rawCmd.Arg("cmd", "Command id, such as 10 (query) or 16 (query using protocol 3.4).").Required().Uint32()
+	rawPayload       = rawCmd.Arg("payload", "Payload, sent as is.").Default("{}").String()
+	diagnoseCmd      = kingpin.Command("diagnose", "Walk through connectivity to device step by step and report outcome of every step.")
+	diagnoseDev      = addDeviceFlags(diagnoseCmd)
+	diagnoseFormat   = diagnoseCmd.Flag("format", "Format of report.").Default("text").Enum("text", "json")
+	scanCmd          = kingpin.Command("scan", "Probe device port across range of addresses and match devices found against configured ids and keys.")
+	scanRangeArg     = scanCmd.Arg("cidr", "Range of addresses, such as 192.168.10.0/24.").Required().String()
+	scanWorkers      = scanCmd.Flag("workers", "Number of hosts probed at once.").Default("64").Int()
+	scanTimeout      = scanCmd.Flag("timeout", "Timeout of connection and of every read and write.").Default("2s").Duration()
+	scanFormat       = scanCmd.Flag("format", "Format of report.").Default("text").Enum("text", "json")
 )
 
 func main() {
@@ -57,24 +92,87 @@ func main() {
 
 	kingpin.Version(pv.Print(progName))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	cmd := kingpin.Parse()
 	logger := promslog.New(promlogConfig)
 
-	logger.Info("Exporter starting", "name", progName, "version", pv.Info(), "config.file", *configFile)
+	switch cmd {
+	case checkConfigCmd.FullCommand():
+		os.Exit(checkConfig(os.Stdout, *checkConfigFiles))
+	case discoverCmd.FullCommand():
+		os.Exit(discoverDevices(*discoverDuration, *discoverListen, os.Stdout, logger))
+	case importCmd.FullCommand():
+		os.Exit(importDevices(*importSource, *importOutput, os.Stdin, os.Stdout, os.Stderr))
+	case queryCmd.FullCommand():
+		os.Exit(queryDevice(queryDev, os.Stdout, logger))
+	case setCmd.FullCommand():
+		os.Exit(setDevice(setDev, *setDps, logger))
+	case watchCmd.FullCommand():
+		os.Exit(watchDevice(watchDev, os.Stdout, logger))
+	case rawCmd.FullCommand():
+		os.Exit(rawDevice(rawDev, *rawCmdId, *rawPayload, os.Stdout, logger))
+	case diagnoseCmd.FullCommand():
+		os.Exit(diagnoseDevice(diagnoseDev, *diagnoseFormat, os.Stdout, logger))
+	case scanCmd.FullCommand():
+		os.Exit(scanRange(*scanRangeArg, *scanWorkers, *scanTimeout, *scanFormat, os.Stdout, logger))
+	}
+
+	logger.Info("Exporter starting", "name", progName, "version", pv.Info(), "config.file", *configFiles)
 	logger.Info("Build context", "build_context", pv.BuildContext())
 
-	cfg, err := loadConfig(*configFile)
+	cfg, err := internal.LoadConfig(*configFiles...)
 
 	if err != nil {
-		logger.Error("Error reading configuration", "err", err, "config.file", *configFile)
+		logger.Error("Error reading configuration", "err", err, "config.file", *configFiles)
 		os.Exit(1)
 	}
 
+	// single write to textfile queries devices directly, as in scrape mode
+	once := *textfile != "" && *textfileInterval <= 0
+	if once && cfg.Polling != nil {
+		logger.Info("Background polling is not used for single write to textfile")
+		cfg.Polling = nil
+	}
+
 	r := prometheus.NewRegistry()
 	r.MustRegister(version.NewCollector(strings.ReplaceAll(progName, " ", "_")))
-	r.MustRegister(exporter.New(cfg, logger))
+	e := exporter.New(cfg, logger)
+	r.MustRegister(e)
+	if cfg.Polling != nil {
+		logger.Info("Background polling enabled", "interval", cfg.Polling.Interval, "staleAfter", cfg.Polling.StaleAfter)
+	}
+	// on termination, sinks are given chance to write remaining data before exporter exits
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runDone := make(chan struct{})
+	if once {
+		close(runDone)
+	} else {
+		go func() {
+			defer close(runDone)
+			e.Run(ctx)
+		}()
+	}
+
+	rl := newReloader(*configFiles, e, logger)
+	rl.loaded(cfg)
+	r.MustRegister(rl)
+	go rl.run(ctx, *configWatchInterval)
 
 	logger.Info("Devices loaded", "count", len(cfg.Devices))
+	for _, name := range slices.Sorted(maps.Keys(cfg.Devices)) {
+		dc := cfg.Devices[name]
+		logger.Info("Device configuration", "device", name, "address", dc.Address, "protocol", dc.Protocol,
+			"profile", dc.Profile, "connectTimeout", dc.ConnectTimeout, "readTimeout", dc.ReadTimeout,
+			"writeTimeout", dc.WriteTimeout, "pollInterval", dc.PollInterval, "extraLabels", lo.FromPtr(dc.ExtraLabels))
+	}
+	if *textfile != "" {
+		// default metrics of exporter process would clash with those of node_exporter
+		logger.Info("Writing metrics to textfile", "path", *textfile, "interval", *textfileInterval)
+		rc := runTextfile(ctx, *textfile, *textfileInterval, cfg.Polling != nil, r, logger)
+		stop()
+		<-runDone
+		os.Exit(rc)
+	}
 	handler := promhttp.HandlerFor(
 		prometheus.Gatherers{r},
 		promhttp.HandlerOpts{
@@ -103,6 +201,14 @@ func main() {
 				Address: "/health",
 				Text:    "Health",
 			},
+			{
+				Address: "/probe",
+				Text:    "Probe",
+			},
+			{
+				Address: "/sd",
+				Text:    "Service discovery",
+			},
 		},
 	})
 	if err != nil {
@@ -115,32 +221,30 @@ func main() {
 		_, _ = w.Write([]byte("OK"))
 	})
 	http.Handle(*telemetryPath, handler)
+	http.Handle("/-/reload", rl)
+	http.Handle("/probe", probeHandler(e))
+	http.Handle("/sd", sdHandler(e))
 
 	srv := &http.Server{
 		ReadHeaderTimeout: 10 * time.Second,
 	}
-	if err = web.ListenAndServe(srv, webConfig, logger); err != nil {
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- web.ListenAndServe(srv, webConfig, logger)
+	}()
+	select {
+	case err = <-srvErr:
 		logger.Error("Error starting server", "err", err)
+		stop()
+		<-runDone
 		os.Exit(1)
+	case <-ctx.Done():
 	}
-}
-
-func loadConfig(path string) (*internal.ConfigSpec, error) {
-	var (
-		err   error
-		bytes []byte
-		cfg   internal.ConfigSpec
-	)
-	bytes, err = os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	err = yaml.Unmarshal(bytes, &cfg)
-	if err != nil {
-		return nil, err
-	}
-	if len(cfg.Devices) == 0 {
-		return nil, errNoDevs
+	logger.Info("Shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(sctx); err != nil {
+		logger.Warn("Error stopping server", "err", err)
 	}
-	return &cfg, nil
+	<-runDone
 }
@@ -0,0 +1,181 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/exporter"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+)
+
+// reloader re-reads configuration file and applies it to exporter.
+type reloader struct {
+	paths []string
+	e     exporter.Exporter
+	l     *slog.Logger
+	lock  sync.Mutex
+	// raw content of configuration files, as of last reload attempt
+	raw []byte
+
+	success   prometheus.Gauge
+	successTs prometheus.Gauge
+	hash      prometheus.Gauge
+}
+
+func newReloader(paths []string, e exporter.Exporter, l *slog.Logger) *reloader {
+	return &reloader{
+		paths: paths,
+		e:     e,
+		l:     l,
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "tuya",
+			Subsystem: "smartplug",
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		}),
+		successTs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "tuya",
+			Subsystem: "smartplug",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		}),
+		hash: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "tuya",
+			Subsystem: "smartplug",
+			Name:      "config_hash",
+			Help:      "Hash of the currently loaded configuration.",
+		}),
+	}
+}
+
+func (r *reloader) Describe(ch chan<- *prometheus.Desc) {
+	r.success.Describe(ch)
+	r.successTs.Describe(ch)
+	r.hash.Describe(ch)
+}
+
+func (r *reloader) Collect(ch chan<- prometheus.Metric) {
+	r.success.Collect(ch)
+	r.successTs.Collect(ch)
+	r.hash.Collect(ch)
+}
+
+// configHash computes hash of loaded configuration, truncated to fit into float64 without loss.
+func configHash(cfg *internal.ConfigSpec) float64 {
+	data, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(data)
+	return float64(binary.BigEndian.Uint64(sum[:8]) >> 12)
+}
+
+// loaded records successful load of initial configuration.
+func (r *reloader) loaded(cfg *internal.ConfigSpec) {
+	r.raw = r.readRaw()
+	r.success.Set(1)
+	r.successTs.SetToCurrentTime()
+	r.hash.Set(configHash(cfg))
+}
+
+func (r *reloader) reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.raw = r.readRaw()
+	cfg, err := internal.LoadConfig(r.paths...)
+	if err != nil {
+		r.l.Error("Configuration reload failed, keeping previous configuration", "err", err, "config.file", r.paths)
+		r.success.Set(0)
+		return err
+	}
+	r.e.Reload(cfg)
+	r.success.Set(1)
+	r.successTs.SetToCurrentTime()
+	r.hash.Set(configHash(cfg))
+	r.l.Info("Configuration reloaded", "config.file", r.paths, "devices", len(cfg.Devices))
+	return nil
+}
+
+// readRaw reads names and content of all configuration files, so that any change, including added or removed
+// file in configuration directory, can be detected.
+func (r *reloader) readRaw() []byte {
+	var buf bytes.Buffer
+	files, _ := internal.ConfigFiles(r.paths)
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		buf.WriteString(file)
+		buf.WriteByte(0)
+		buf.Write(data)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// changed reports whether configuration files differ from those seen during last reload attempt.
+func (r *reloader) changed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return !bytes.Equal(r.readRaw(), r.raw)
+}
+
+// run reloads configuration upon SIGHUP and, if interval is positive, when file content changes.
+func (r *reloader) run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			_ = r.reload()
+		case <-tick:
+			if r.changed() {
+				_ = r.reload()
+			}
+		}
+	}
+}
+
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		http.Error(w, "failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte("OK"))
+}
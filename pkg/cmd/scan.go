@@ -0,0 +1,80 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+)
+
+// scanRange probes device port across range of addresses and reports which configured device answers at each of them.
+// Returned value is suitable as process exit code.
+func scanRange(cidr string, workers int, timeout time.Duration, format string, out io.Writer, l *slog.Logger) int {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		l.Error("Invalid range", "err", err)
+		return 1
+	}
+	cfg, err := internal.LoadConfig(*configFiles...)
+	if err != nil {
+		l.Error("Error reading configuration", "err", err, "config.file", *configFiles)
+		return 1
+	}
+	opts := internal.ScanOptions{
+		Workers: workers,
+		Timeout: timeout,
+		Logger:  l,
+	}
+	if cfg.Defaults != nil {
+		opts.Dialer = cfg.Defaults.Dialer
+	}
+	l.Info("Scanning range", "range", prefix, "workers", workers, "devices", len(cfg.Devices))
+	res, err := internal.Scan(context.Background(), prefix, cfg.Devices, opts)
+	if err != nil {
+		l.Error("Unable to scan range", "err", err)
+		return 1
+	}
+	l.Info("Scan finished", "hosts", len(res))
+	if format == "json" {
+		_ = printJson(out, res)
+		return 0
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ADDRESS\tDEVICE\tPROTOCOL\tNOTE")
+	for _, r := range res {
+		note := ""
+		switch {
+		case r.Device == "":
+			note = "no configured id and key matches"
+		case r.Stale:
+			note = "address out of date, configured " + r.ConfiguredAddress
+		case r.ConfiguredAddress == "":
+			note = "address not configured"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Address, lo.CoalesceOrEmpty(r.Device, "-"), lo.CoalesceOrEmpty(r.Protocol, "-"), note)
+	}
+	_ = tw.Flush()
+	return 0
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// runTextfile writes metrics to file for textfile collector of node_exporter.
+// When interval is 0, metrics are written once, otherwise they are written periodically until context is cancelled.
+// First write is delayed by single interval if wait is set, so that background polling has chance to query devices.
+// Returned value is suitable as process exit code.
+func runTextfile(ctx context.Context, path string, interval time.Duration, wait bool, g prometheus.Gatherer, l *slog.Logger) int {
+	if interval <= 0 {
+		if err := writeTextfile(path, g, l); err != nil {
+			l.Error("Unable to write textfile", "path", path, "err", err)
+			return 1
+		}
+		return 0
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if !wait {
+			if err := writeTextfile(path, g, l); err != nil {
+				l.Error("Unable to write textfile", "path", path, "err", err)
+			}
+		}
+		wait = false
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-t.C:
+		}
+	}
+}
+
+// writeTextfile gathers metrics and atomically replaces file with them, in text exposition format.
+// Like HTTP endpoint, metrics gathered successfully are written even if gathering of others failed.
+func writeTextfile(path string, g prometheus.Gatherer, l *slog.Logger) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		l.Warn("Error while gathering metrics", "err", err)
+	}
+	// leading dot keeps temporary file out of *.prom glob used by node_exporter
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	enc := expfmt.NewEncoder(tmp, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range mfs {
+		if err = enc.Encode(mf); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	l.Debug("Textfile written", "path", path, "families", len(mfs))
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCollector emits single gauge per device.
+type testCollector struct {
+	desc    *prometheus.Desc
+	devices map[string]float64
+}
+
+func newTestCollector(devices map[string]float64) *testCollector {
+	return &testCollector{
+		desc:    prometheus.NewDesc("tuya_smartplug_power", "Power used", []string{"device"}, nil),
+		devices: devices,
+	}
+}
+
+func (c *testCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *testCollector) Collect(ch chan<- prometheus.Metric) {
+	for dname, v := range c.devices {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, v, dname)
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugs.prom")
+	assert.NoError(t, os.WriteFile(path, []byte("old\n"), 0o600))
+	// reader that opened file before the write keeps seeing old content, file is replaced rather than rewritten
+	old, err := os.Open(path)
+	assert.NoError(t, err)
+	defer func() {
+		_ = old.Close()
+	}()
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(newTestCollector(map[string]float64{"kitchen": 10.5, "desk": 2}))
+	assert.NoError(t, writeTextfile(path, r, slog.New(slog.DiscardHandler)))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP tuya_smartplug_power Power used
+# TYPE tuya_smartplug_power gauge
+tuya_smartplug_power{device="desk"} 2
+tuya_smartplug_power{device="kitchen"} 10.5
+`, string(data))
+	oldData, err := io.ReadAll(old)
+	assert.NoError(t, err)
+	assert.Equal(t, "old\n", string(oldData))
+
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), fi.Mode().Perm())
+
+	// temporary file is gone
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "plugs.prom", entries[0].Name())
+}
+
+func TestRunTextfileOnce(t *testing.T) {
+	l := slog.New(slog.DiscardHandler)
+	r := prometheus.NewRegistry()
+	r.MustRegister(newTestCollector(map[string]float64{"desk": 2}))
+	path := filepath.Join(t.TempDir(), "plugs.prom")
+	assert.Equal(t, 0, runTextfile(t.Context(), path, 0, false, r, l))
+	assert.FileExists(t, path)
+	assert.Equal(t, 1, runTextfile(t.Context(), filepath.Join(t.TempDir(), "missing", "plugs.prom"), 0, false, r, l))
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"time"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultProbeInterval    = 30 * time.Second
+	defaultMaxProbeInterval = 30 * time.Minute
+)
+
+// breaker stops querying device after number of consecutive failures.
+// Once open, it lets single probe through on exponential schedule.
+type breaker struct {
+	threshold   int
+	interval    time.Duration
+	maxInterval time.Duration
+
+	state    breakerState
+	failures int
+	// number of failed probes since breaker opened
+	probes    int
+	nextProbe time.Time
+}
+
+func newBreaker(spec *internal.CircuitBreakerSpec) *breaker {
+	b := &breaker{
+		threshold:   defaultFailureThreshold,
+		interval:    defaultProbeInterval,
+		maxInterval: defaultMaxProbeInterval,
+	}
+	if spec.FailureThreshold > 0 {
+		b.threshold = spec.FailureThreshold
+	}
+	if spec.ProbeInterval > 0 {
+		b.interval = spec.ProbeInterval
+	}
+	if spec.MaxProbeInterval > 0 {
+		b.maxInterval = spec.MaxProbeInterval
+	}
+	return b
+}
+
+// allow reports whether device can be queried now. When probe is due, breaker moves to half-open state.
+func (b *breaker) allow(now time.Time) bool {
+	switch b.state {
+	case breakerOpen:
+		if now.Before(b.nextProbe) {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// probe is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *breaker) success() {
+	b.state = breakerClosed
+	b.failures = 0
+	b.probes = 0
+}
+
+func (b *breaker) failure(now time.Time) {
+	b.failures++
+	switch b.state {
+	case breakerHalfOpen:
+		b.probes++
+	case breakerClosed:
+		if b.failures < b.threshold {
+			return
+		}
+	}
+	b.state = breakerOpen
+	b.nextProbe = now.Add(b.probeInterval())
+}
+
+func (b *breaker) probeInterval() time.Duration {
+	d := b.interval
+	for i := 0; i < b.probes && d < b.maxInterval; i++ {
+		d *= 2
+	}
+	return min(d, b.maxInterval)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(&internal.CircuitBreakerSpec{
+		FailureThreshold: 2,
+		ProbeInterval:    time.Minute,
+		MaxProbeInterval: 3 * time.Minute,
+	})
+	assert.True(t, b.allow(now))
+	b.failure(now)
+	assert.Equal(t, breakerClosed, b.state)
+	b.failure(now)
+	assert.Equal(t, breakerOpen, b.state)
+	assert.False(t, b.allow(now.Add(30*time.Second)))
+
+	// failed probe doubles interval
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow(now))
+	assert.Equal(t, breakerHalfOpen, b.state)
+	assert.False(t, b.allow(now))
+	b.failure(now)
+	assert.Equal(t, breakerOpen, b.state)
+	assert.False(t, b.allow(now.Add(90*time.Second)))
+
+	// interval is capped
+	now = now.Add(2 * time.Minute)
+	assert.True(t, b.allow(now))
+	b.failure(now)
+	assert.Equal(t, now.Add(3*time.Minute), b.nextProbe)
+
+	// successful probe closes breaker
+	now = now.Add(3 * time.Minute)
+	assert.True(t, b.allow(now))
+	b.success()
+	assert.Equal(t, breakerClosed, b.state)
+	assert.True(t, b.allow(now))
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"net"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+)
+
+func (e *exporter) discovering() bool {
+	return e.cfg.Discovery != nil
+}
+
+// startDiscovery starts listening for device broadcasts, if discovery is enabled and listener is not running yet.
+// Caller must hold write lock.
+func (e *exporter) startDiscovery() {
+	if e.runCtx == nil || !e.discovering() || e.discCancel != nil {
+		return
+	}
+	addrs := internal.DiscoveryAddrs
+	if e.cfg.Discovery.Listen != nil {
+		addrs = *e.cfg.Discovery.Listen
+	}
+	ctx, cancel := context.WithCancel(e.runCtx)
+	done := make(chan struct{})
+	e.discCancel = cancel
+	e.discDone = done
+	e.l.Info("listening for device broadcasts", "addresses", addrs)
+	go func() {
+		defer close(done)
+		if err := e.disc.Listen(ctx, addrs...); err != nil {
+			e.l.Error("unable to listen for device broadcasts", "addresses", addrs, "error", err)
+		}
+	}()
+}
+
+// stopDiscovery stops listening for device broadcasts and waits until sockets are closed,
+// so that listener can be started on the same ports right away. Table of discovered devices is kept.
+// Caller must hold write lock.
+func (e *exporter) stopDiscovery() {
+	if e.discCancel != nil {
+		e.discCancel()
+		<-e.discDone
+		e.discCancel = nil
+		e.discDone = nil
+	}
+}
+
+// resolver returns function that provides current address of device.
+// Address from latest broadcast of device takes precedence, configured address is used until device is discovered.
+// Port, if configured, is kept.
+func (e *exporter) resolver(dname string, dc internal.DeviceConnectionSpec) func() string {
+	return func() string {
+		addr := dc.Address
+		if dev, ok := e.disc.Lookup(dc.Id); ok {
+			addr = dev.Ip
+			if _, port, err := net.SplitHostPort(dc.Address); err == nil {
+				addr = net.JoinHostPort(dev.Ip, port)
+			}
+		}
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		ds := e.stateOf(dname)
+		if addr == "" {
+			return ds.address
+		}
+		if ds.address != "" && ds.address != addr {
+			ds.addressChanges++
+			e.l.Info("device address changed", "device", dname, "from", ds.address, "to", addr)
+		}
+		ds.address = addr
+		return addr
+	}
+}
@@ -17,82 +17,82 @@ limitations under the License.
 package exporter
 
 import (
+	"context"
 	"log/slog"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/rkosegi/tuya-proto/proto"
 	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
 	"github.com/samber/lo"
+	"golang.org/x/sync/singleflight"
 )
 
 type exporter struct {
-	m       GlobalMetrics
-	cfg     *internal.ConfigSpec
-	l       *slog.Logger
-	clients map[string]internal.Client
+	m GlobalMetrics
+	// guards cfg, clients and pollers, write lock is only held during reload
+	cfgLock sync.RWMutex
+	// serializes reloads and shutdown, so that sink is completely stopped before it's started again
+	reloadLock sync.Mutex
+	cfg        *internal.ConfigSpec
+	l          *slog.Logger
+	clients    map[string]internal.Client
+	lock       sync.Mutex
+	state      map[string]*deviceState
+	sf         singleflight.Group
+	// limits number of concurrent device queries, nil if unlimited
+	sem chan struct{}
+	// position of device in name order, used for staggering
+	order map[string]int
+	// context passed to Run, nil until polling starts
+	runCtx  context.Context
+	pollers map[string]context.CancelFunc
+	// table of devices found by their broadcasts, kept across reloads
+	disc       *internal.Discovery
+	discCancel context.CancelFunc
+	// closed once listener released its sockets
+	discDone chan struct{}
+	// creates client of device, replaced in tests
+	newClient func(dname string, dc internal.DeviceConnectionSpec) internal.Client
+	dialMqtt  mqttDialer
+	// nil unless MQTT publishing is running
+	mqtt *publisher
+	// Home Assistant discovery topics published per device, kept across restarts of publisher
+	mqttAnnounced map[string][]string
+	// nil unless writing to InfluxDB is running
+	influx *influxSink
+	// nil unless OTLP export is running
+	otlp *otlpSink
+	// nil unless remote write is running
+	remoteWrite *remoteWriteSink
 }
 
 func (e *exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.m.Error.Describe(ch)
 	e.m.TotalScrapes.Describe(ch)
+	e.m.SinkQueueLength.Describe(ch)
+	e.m.SinkWritten.Describe(ch)
+	e.m.SinkDropped.Describe(ch)
+	e.m.SinkFailures.Describe(ch)
 }
 
-func (e *exporter) clientForDevice(dc internal.DeviceConnectionSpec) internal.Client {
-	ver := proto.Version31
-	if dc.Protocol == "tuya3.4" {
-		ver = proto.Version34
-	}
-	if dc.ConnectTimeout == 0 {
-		dc.ConnectTimeout = time.Second * 10
-	}
-	if dc.ReadTimeout == 0 {
-		dc.ReadTimeout = time.Second * 10
-	}
-	if dc.WriteTimeout == 0 {
-		dc.WriteTimeout = time.Second * 10
-	}
-	return internal.NewClient(ver, dc.Address, []byte(dc.Key),
-		internal.WithTimeout(dc.ConnectTimeout),
-		internal.WithReadTimeout(dc.ReadTimeout),
-		internal.WithWriteTimeout(dc.WriteTimeout),
+func (e *exporter) clientForDevice(dname string, dc internal.DeviceConnectionSpec) internal.Client {
+	opts := []internal.Opt{
 		internal.WithLogger(e.l.With("address", dc.Address, "protocol", dc.Protocol)),
-	)
-}
-
-func (e *exporter) statusForDevice(name string) (*internal.DpQueryResponse, *internal.ProtoStats, error) {
-	cl := e.clients[name]
-	dc := e.cfg.Devices[name]
-	var err error
-	if !cl.IsConnected() {
-		if err = cl.Connect(); err != nil {
-			return nil, new(cl.Stats()), err
-		}
 	}
-	if dc.Protocol == "tuya3.4" {
-		if err = cl.Send(proto.CmdIdTypeDpQueryNew, make(map[string]interface{})); err != nil {
-			return nil, new(cl.Stats()), err
-		}
-	} else {
-		if err = cl.Send(proto.CmdIdTypeDpQuery, internal.DpQueryRequest{
-			GwId:  dc.Id,
-			DevId: dc.Id,
-		}); err != nil {
-			return nil, new(cl.Stats()), err
-		}
+	// devices which are not configured (dname is empty) are always queried at given address
+	if dname != "" && e.discovering() {
+		opts = append(opts, internal.WithResolver(e.resolver(dname, dc)))
 	}
-	defer func() {
-		_ = cl.Close()
-	}()
-	var out internal.DpQueryResponse
-	if err = cl.Read(&out); err != nil {
-		return nil, new(cl.Stats()), err
-	}
-	return &out, new(cl.Stats()), nil
+	return internal.NewDeviceClient(dc, opts...)
 }
 
 func (e *exporter) Collect(ch chan<- prometheus.Metric) {
+	e.cfgLock.RLock()
+	names := slices.Collect(maps.Keys(e.cfg.Devices))
+	e.cfgLock.RUnlock()
 	e.m.Error.Set(0)
 	startAny := time.Now()
 	var wg sync.WaitGroup
@@ -101,26 +101,315 @@ func (e *exporter) Collect(ch chan<- prometheus.Metric) {
 		e.m.TotalScrapes.Observe(time.Since(startAny).Seconds())
 		e.m.Error.Collect(ch)
 		e.m.TotalScrapes.Collect(ch)
+		e.m.SinkQueueLength.Collect(ch)
+		e.m.SinkWritten.Collect(ch)
+		e.m.SinkDropped.Collect(ch)
+		e.m.SinkFailures.Collect(ch)
 	}()
-	for dname := range e.cfg.Devices {
+	for _, dname := range names {
 		wg.Add(1)
 		go e.collectDevice(dname, ch, &wg)
 	}
 }
 
+// deviceQuery holds everything needed to query device, so that configuration lock isn't held during device I/O.
+type deviceQuery struct {
+	cl internal.Client
+	dc internal.DeviceConnectionSpec
+	// limits number of concurrent device queries, nil if unlimited
+	sem   chan struct{}
+	retry *internal.RetrySpec
+}
+
+// newQuery captures settings needed to query device.
+// Caller must hold read lock.
+func (e *exporter) newQuery(cl internal.Client, dc internal.DeviceConnectionSpec) *deviceQuery {
+	return &deviceQuery{
+		cl:    cl,
+		dc:    dc,
+		sem:   e.sem,
+		retry: e.cfg.Retry,
+	}
+}
+
+// queryDevice performs single query of device status. Configuration lock doesn't need to be held.
+func (e *exporter) queryDevice(dname string, q *deviceQuery) *reading {
+	release := acquire(q.sem)
+	defer release()
+	start := time.Now()
+	var (
+		status *internal.DpQueryResponse
+		stats  *internal.ProtoStats
+		plug   internal.PlugReading
+		err    error
+	)
+	for attempt := 0; ; attempt++ {
+		status, err = internal.QueryDps(q.cl, q.dc)
+		stats = new(q.cl.Stats())
+		if err == nil {
+			if plug, err = decodeStatus(q.dc.Profile, status); err != nil {
+				// device keeps reporting data points the same way, retry won't help
+				break
+			}
+		}
+		if err == nil || q.retry == nil || attempt >= q.retry.Attempts || !internal.IsTransient(err) {
+			break
+		}
+		backoff := retryBackoff(q.retry, attempt)
+		e.l.Debug("retrying query", "device", dname, "attempt", attempt+1, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+	}
+	r := &reading{
+		status: status,
+		plug:   plug,
+		stats:  stats,
+		err:    err,
+		at:     time.Now(),
+		took:   time.Since(start),
+	}
+	if err != nil {
+		r.reason = internal.ErrorReason(err)
+		e.l.Warn("error during scrape", "device", dname, "reason", r.reason, "error", err)
+	} else {
+		e.l.Debug("Status of device", "device", dname, "status", status.Dps)
+	}
+	return r
+}
+
+// decodeStatus decodes status of device using its profile.
+func decodeStatus(profileName string, status *internal.DpQueryResponse) (internal.PlugReading, error) {
+	profile, _ := internal.LookupProfile(profileName)
+	pr, err := profile.Decode(status.Dps)
+	if err != nil {
+		return pr, &internal.OpError{Op: internal.OpDecode, Err: err}
+	}
+	return pr, nil
+}
+
+// fetch returns current reading of device. Concurrent calls for the same device are coalesced into single query.
+// When device was queried within configured minimal interval, last reading is returned instead.
+// Nil is returned for device that is not configured, or that was never queried because its circuit breaker is open.
+// Read lock is taken only around device I/O, so that reload doesn't wait for slow devices. Caller must not hold it.
+func (e *exporter) fetch(dname string) *reading {
+	v, _, _ := e.sf.Do(dname, func() (any, error) {
+		q, r := e.prepareFetch(dname)
+		if q == nil {
+			return r, nil
+		}
+		return e.refresh(dname, q), nil
+	})
+	return v.(*reading)
+}
+
+// control sends command to device using its shared client, then queries device for its new state.
+// Command is sent once query of device in progress finishes, fetches started meanwhile receive reading taken after command.
+// Nil reading is returned for device that is not configured. Caller must not hold read lock.
+func (e *exporter) control(dname string, cmd func(cl internal.Client, dc internal.DeviceConnectionSpec) error) (*reading, error) {
+	for {
+		var (
+			ran bool
+			err error
+		)
+		v, _, _ := e.sf.Do(dname, func() (any, error) {
+			ran = true
+			e.cfgLock.RLock()
+			cl, ok := e.clients[dname]
+			var q *deviceQuery
+			if ok {
+				q = e.newQuery(cl, e.cfg.Devices[dname])
+			}
+			e.cfgLock.RUnlock()
+			if q == nil {
+				return (*reading)(nil), nil
+			}
+			release := acquire(q.sem)
+			err = cmd(q.cl, q.dc)
+			release()
+			// state is refreshed even if command failed, as fetches waiting for it need current reading
+			return e.refresh(dname, q), nil
+		})
+		// call joined query which was already in progress, command is sent once it finishes
+		if ran {
+			return v.(*reading), err
+		}
+	}
+}
+
+// refresh queries device and records its reading, unless device was changed or removed by reload in the meantime.
+func (e *exporter) refresh(dname string, q *deviceQuery) *reading {
+	r := e.queryDevice(dname, q)
+	e.cfgLock.RLock()
+	defer e.cfgLock.RUnlock()
+	if e.clients[dname] == q.cl {
+		e.record(dname, r)
+		e.pushReading(dname, r)
+	}
+	return r
+}
+
+// prepareFetch returns query of device, or nil along with reading to use instead when device shouldn't be queried.
+func (e *exporter) prepareFetch(dname string) (*deviceQuery, *reading) {
+	e.cfgLock.RLock()
+	defer e.cfgLock.RUnlock()
+	cl, ok := e.clients[dname]
+	if !ok {
+		return nil, nil
+	}
+	if r := e.recent(dname); r != nil {
+		return nil, r
+	}
+	if r, skip := e.breakerOpen(dname); skip {
+		return nil, r
+	}
+	return e.newQuery(cl, e.cfg.Devices[dname]), nil
+}
+
+// recent returns last reading if it's younger than minimal query interval.
+// Caller must hold read lock.
+func (e *exporter) recent(dname string) *reading {
+	if e.cfg.MinQueryInterval <= 0 {
+		return nil
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if ds, ok := e.state[dname]; ok && ds.last != nil && time.Since(ds.last.at) < e.cfg.MinQueryInterval {
+		return ds.last
+	}
+	return nil
+}
+
+// breakerOpen reports whether device query should be skipped because circuit breaker is open.
+// In such case, last reading is returned.
+func (e *exporter) breakerOpen(dname string) (*reading, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if ds, ok := e.state[dname]; ok && ds.breaker != nil && !ds.breaker.allow(time.Now()) {
+		return ds.last, true
+	}
+	return nil, false
+}
+
+// stateOf returns state of device, creating it if needed.
+// Caller must hold lock.
+func (e *exporter) stateOf(dname string) *deviceState {
+	ds, ok := e.state[dname]
+	if !ok {
+		ds = &deviceState{errors: map[string]int{}}
+		if e.cfg.CircuitBreaker != nil {
+			ds.breaker = newBreaker(e.cfg.CircuitBreaker)
+		}
+		e.state[dname] = ds
+	}
+	return ds
+}
+
+// record stores reading as the latest known state of device.
+// Caller must hold read lock.
+func (e *exporter) record(dname string, r *reading) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ds := e.stateOf(dname)
+	ds.last = r
+	ds.queries++
+	ds.queryTime += r.took
+	if r.err != nil {
+		ds.errors[r.reason]++
+		if ds.breaker != nil {
+			ds.breaker.failure(r.at)
+			if ds.breaker.state == breakerOpen {
+				e.l.Info("circuit breaker open", "device", dname, "failures", ds.breaker.failures, "nextProbe", ds.breaker.nextProbe)
+			}
+		}
+	} else {
+		if prev := ds.lastGood; prev != nil && r.at.Sub(prev.at) <= e.staleAfter(dname) {
+			ds.energy += estimateEnergy(prev, r)
+		}
+		ds.lastGood = r
+		if ds.breaker != nil {
+			ds.breaker.success()
+		}
+	}
+}
+
+func (e *exporter) snapshot(dname string) (deviceState, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ds, ok := e.state[dname]
+	// state might exist before first query finished
+	if !ok || ds.last == nil {
+		return deviceState{}, false
+	}
+	out := *ds
+	out.errors = maps.Clone(ds.errors)
+	if ds.breaker != nil {
+		b := *ds.breaker
+		out.breaker = &b
+	}
+	return out, true
+}
+
+// collectDevice sends metrics of configured device, querying it first unless polling is enabled.
 func (e *exporter) collectDevice(dname string, ch chan<- prometheus.Metric, wg *sync.WaitGroup) {
 	defer wg.Done()
+	e.cfgLock.RLock()
+	polling := e.polling()
+	delay := e.startDelay(dname)
+	e.cfgLock.RUnlock()
+	if !polling {
+		// queries of single scrape are spread out
+		time.Sleep(delay)
+		e.fetch(dname)
+	}
+	e.cfgLock.RLock()
+	defer e.cfgLock.RUnlock()
+	dc, ok := e.cfg.Devices[dname]
+	if !ok {
+		// removed by reload in the meantime
+		return
+	}
+	ds, ok := e.snapshot(dname)
+	if !ok {
+		// not polled yet
+		return
+	}
+	if ds.last.err != nil {
+		e.m.Error.Set(1)
+	}
+	e.emitDevice(dname, dc, ds, ch)
+}
+
+// estimateEnergy returns energy used between two successful readings in kWh, assuming power changed linearly.
+func estimateEnergy(prev, cur *reading) float64 {
+	avg := (prev.plug.Power + cur.plug.Power) / 2
+	return avg * cur.at.Sub(prev.at).Hours() / 1000
+}
+
+// goodReading returns reading which values should be reported, if any.
+// In scrape mode, values are only reported when current query succeeded,
+// in polling mode last known values are reported until they become stale.
+func (e *exporter) goodReading(dname string, ds deviceState) *reading {
+	good := ds.last
+	if e.polling() {
+		good = ds.lastGood
+	}
+	if good != nil && good.err == nil && (!e.polling() || time.Since(good.at) <= e.staleAfter(dname)) {
+		return good
+	}
+	return nil
+}
+
+// emitDevice sends metrics of device, based on its state.
+func (e *exporter) emitDevice(dname string, devCfg internal.DeviceConnectionSpec, ds deviceState, ch chan<- prometheus.Metric) {
 	m := e.newDeviceMetrics()
-	start := time.Now()
 	labels := prometheus.Labels{"device": dname}
-	status, stats, err := e.statusForDevice(dname)
-	devCfg := e.cfg.Devices[dname]
-	if devCfg.ExtraLabels != nil {
-		for lk, lv := range *devCfg.ExtraLabels {
-			labels[lk] = lv
-		}
+	// same labels in order of declaration, for constant metrics
+	values := []string{dname}
+	for _, ln := range e.cfg.ExtraLabelNames() {
+		// device that is not configured has no extra labels
+		labels[e.cfg.ExportedLabelName(ln)] = lo.FromPtr(devCfg.ExtraLabels)[ln]
+		values = append(values, lo.FromPtr(devCfg.ExtraLabels)[ln])
 	}
-	if stats != nil {
+	if stats := ds.last.stats; stats != nil {
 		m.ReadPackets.With(labels).Add(float64(stats.ReadPkts))
 		m.SentPackets.With(labels).Add(float64(stats.SentPkts))
 		if stats.ReadErrs > 0 {
@@ -130,22 +419,29 @@ func (e *exporter) collectDevice(dname string, ch chan<- prometheus.Metric, wg *
 			m.SentErrors.With(labels).Add(float64(stats.SentErrs))
 		}
 	}
-	if err != nil {
-		e.l.Warn("error during scrape", "device", dname, "error", err)
-		m.ScrapeErrors.With(labels).Inc()
-		e.m.Error.Set(1)
-	} else {
-		e.l.Debug("Status of device", "device", dname, "status", status.Dps)
+	for reason, cnt := range ds.errors {
+		m.ScrapeErrors.MustCurryWith(labels).WithLabelValues(reason).Add(float64(cnt))
+	}
+	if good := e.goodReading(dname, ds); good != nil {
 		ison := 0
-		m.Current.With(labels).Set(float64(status.Dps.Current) / 1000)
-		m.Voltage.With(labels).Set(float64(status.Dps.Voltage) / 10)
-		m.Power.With(labels).Set(float64(status.Dps.Power) / 10)
-		if status.Dps.SwitchOn {
+		pr := good.plug
+		m.Current.With(labels).Set(pr.Current)
+		m.Voltage.With(labels).Set(pr.Voltage)
+		m.Power.With(labels).Set(pr.Power)
+		if pr.SwitchOn {
 			ison = 1
 		}
 		m.SwitchOn.With(labels).Set(float64(ison))
+		if e.polling() {
+			m.ReadingAge.With(labels).Set(time.Since(good.at).Seconds())
+		}
+	}
+	if ds.breaker != nil {
+		m.BreakerState.With(labels).Set(float64(ds.breaker.state))
+	}
+	if e.discovering() {
+		m.AddressChanges.With(labels).Add(float64(ds.addressChanges))
 	}
-	m.ScrapeDuration.With(labels).Observe(time.Since(start).Seconds())
 
 	m.SwitchOn.Collect(ch)
 	m.Current.Collect(ch)
@@ -155,19 +451,30 @@ func (e *exporter) collectDevice(dname string, ch chan<- prometheus.Metric, wg *
 	m.SentPackets.Collect(ch)
 	m.ReadErrors.Collect(ch)
 	m.SentErrors.Collect(ch)
-	m.ScrapeDuration.Collect(ch)
-
+	ch <- prometheus.MustNewConstSummary(m.ScrapeDuration, ds.queries, ds.queryTime.Seconds(), nil, values...)
+	m.ScrapeErrors.Collect(ch)
+	m.ReadingAge.Collect(ch)
+	m.BreakerState.Collect(ch)
+	m.AddressChanges.Collect(ch)
 }
 
-func New(cfg *internal.ConfigSpec, logger *slog.Logger) prometheus.Collector {
+// New creates new exporter for devices in given configuration.
+func New(cfg *internal.ConfigSpec, logger *slog.Logger) Exporter {
 	e := &exporter{
-		m:   newCommonMetrics(),
-		cfg: cfg,
-		l:   logger,
+		m:             newCommonMetrics(),
+		cfg:           cfg,
+		l:             logger,
+		state:         map[string]*deviceState{},
+		pollers:       map[string]context.CancelFunc{},
+		disc:          internal.NewDiscovery(logger),
+		mqttAnnounced: map[string][]string{},
 	}
+	e.newClient = e.clientForDevice
+	e.dialMqtt = dialPaho
+	e.setupLimits()
 	// create mapping dev-name to client
 	e.clients = lo.MapEntries(cfg.Devices, func(name string, dc internal.DeviceConnectionSpec) (string, internal.Client) {
-		return name, e.clientForDevice(dc)
+		return name, e.newClient(name, dc)
 	})
 	return e
 }
@@ -0,0 +1,483 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rkosegi/tuya-proto/proto"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClient struct {
+	queries atomic.Int32
+	delay   time.Duration
+	// data points reported by device, typical readings when nil
+	dps       map[string]any
+	connected bool
+	lock      sync.Mutex
+	sent      []proto.CmdIdType
+	// errors returned by consecutive connection attempts, before they start to succeed
+	connectErrs []error
+	connects    []time.Time
+}
+
+func (f *fakeClient) Close() error {
+	f.connected = false
+	return nil
+}
+
+func (f *fakeClient) Read(dest any) error {
+	time.Sleep(f.delay)
+	dest.(*internal.DpQueryResponse).Dps = lo.CoalesceMapOrEmpty(f.dps, map[string]any{"1": true, "18": 50.0, "19": 100.0, "20": 2300.0})
+	return nil
+}
+
+func (f *fakeClient) Send(cmd proto.CmdIdType, _ any) error {
+	f.lock.Lock()
+	f.sent = append(f.sent, cmd)
+	f.lock.Unlock()
+	f.queries.Add(1)
+	return nil
+}
+
+func (f *fakeClient) Connect() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.connects = append(f.connects, time.Now())
+	if len(f.connectErrs) > 0 {
+		err := f.connectErrs[0]
+		f.connectErrs = f.connectErrs[1:]
+		return err
+	}
+	f.connected = true
+	return nil
+}
+
+func (f *fakeClient) IsConnected() bool {
+	return f.connected
+}
+
+func (f *fakeClient) Stats() internal.ProtoStats {
+	return internal.ProtoStats{}
+}
+
+func newTestExporter(cfg *internal.ConfigSpec, clients map[string]internal.Client) *exporter {
+	e := New(cfg, slog.New(slog.DiscardHandler)).(*exporter)
+	e.clients = clients
+	return e
+}
+
+func TestFetchCoalesced(t *testing.T) {
+	fc := &fakeClient{delay: 100 * time.Millisecond}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+	}, map[string]internal.Client{"dev1": fc})
+
+	var wg sync.WaitGroup
+	res := make([]*reading, 5)
+	for i := range res {
+		wg.Go(func() {
+			res[i] = e.fetch("dev1")
+		})
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), fc.queries.Load())
+	for _, r := range res {
+		assert.Same(t, res[0], r)
+	}
+}
+
+func TestPolling(t *testing.T) {
+	fc := &fakeClient{}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+		Polling: &internal.PollingSpec{Interval: time.Hour, StaleAfter: time.Minute},
+	}, map[string]internal.Client{"dev1": fc})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+	gather := func() map[string]*dto.MetricFamily {
+		mfs, err := reg.Gather()
+		assert.NoError(t, err)
+		return lo.KeyBy(mfs, func(mf *dto.MetricFamily) string {
+			return mf.GetName()
+		})
+	}
+
+	// device is not queried by scrape, it has no values until first poll
+	mfs := gather()
+	assert.Equal(t, int32(0), fc.queries.Load())
+	assert.NotContains(t, mfs, "tuya_smartplug_power")
+
+	r := e.fetch("dev1")
+	for range 3 {
+		mfs = gather()
+	}
+	assert.Equal(t, int32(1), fc.queries.Load())
+	assert.Equal(t, 10.0, mfs["tuya_smartplug_power"].GetMetric()[0].GetGauge().GetValue())
+	assert.Less(t, mfs["tuya_smartplug_reading_age_seconds"].GetMetric()[0].GetGauge().GetValue(), 1.0)
+	// duration is observed per query, not per scrape
+	assert.Equal(t, uint64(1), mfs["tuya_smartplug_scrape_duration"].GetMetric()[0].GetSummary().GetSampleCount())
+	r = e.fetch("dev1")
+	gather()
+	assert.Equal(t, uint64(2), gather()["tuya_smartplug_scrape_duration"].GetMetric()[0].GetSummary().GetSampleCount())
+
+	e.lock.Lock()
+	r.at = time.Now().Add(-10 * time.Second)
+	e.lock.Unlock()
+	age := gather()["tuya_smartplug_reading_age_seconds"].GetMetric()[0].GetGauge().GetValue()
+	assert.GreaterOrEqual(t, age, 10.0)
+	assert.Less(t, age, 11.0)
+
+	// stale values are dropped, while device itself is still reported
+	e.lock.Lock()
+	r.at = time.Now().Add(-2 * time.Minute)
+	e.lock.Unlock()
+	mfs = gather()
+	assert.NotContains(t, mfs, "tuya_smartplug_power")
+	assert.NotContains(t, mfs, "tuya_smartplug_reading_age_seconds")
+	assert.Contains(t, mfs, "tuya_smartplug_scrape_duration")
+	assert.Equal(t, int32(2), fc.queries.Load())
+}
+
+func TestFetchMinInterval(t *testing.T) {
+	fc := &fakeClient{}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices:          internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+		MinQueryInterval: time.Hour,
+	}, map[string]internal.Client{"dev1": fc})
+
+	r1 := e.fetch("dev1")
+	r2 := e.fetch("dev1")
+	assert.Equal(t, int32(1), fc.queries.Load())
+	assert.Same(t, r1, r2)
+	assert.NoError(t, r1.err)
+	assert.Equal(t, true, r1.status.Dps["1"])
+}
+
+func TestFetchDecodeError(t *testing.T) {
+	fc := &fakeClient{dps: map[string]any{"1": true, "18": 50.0, "19": "100", "20": 2300.0}}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+		Retry:   &internal.RetrySpec{Attempts: 2},
+	}, map[string]internal.Client{"dev1": fc})
+
+	r := e.fetch("dev1")
+	assert.ErrorIs(t, r.err, internal.ErrBadDataPoint)
+	assert.Equal(t, internal.ReasonDecodeError, r.reason)
+	// decode errors are not retried
+	assert.Equal(t, int32(1), fc.queries.Load())
+}
+
+func TestControl(t *testing.T) {
+	fc := &fakeClient{delay: 50 * time.Millisecond}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+	}, map[string]internal.Client{"dev1": fc})
+	go e.fetch("dev1")
+	assert.Eventually(t, func() bool {
+		return fc.queries.Load() == 1
+	}, time.Second, time.Millisecond)
+
+	started, proceed := make(chan struct{}), make(chan struct{})
+	joined := make(chan *reading)
+	go func() {
+		<-started
+		go func() {
+			joined <- e.fetch("dev1")
+		}()
+		time.Sleep(20 * time.Millisecond)
+		close(proceed)
+	}()
+	r, err := e.control("dev1", func(cl internal.Client, dc internal.DeviceConnectionSpec) error {
+		// command uses shared client once query in progress is recorded
+		assert.Same(t, fc, cl)
+		_, ok := e.snapshot("dev1")
+		assert.True(t, ok)
+		close(started)
+		<-proceed
+		return internal.SetDps(cl, dc, map[string]any{"1": false})
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, r.err)
+	// fetch started while command was sent gets reading taken after it
+	assert.Same(t, r, <-joined)
+	ds, _ := e.snapshot("dev1")
+	assert.Same(t, r, ds.last)
+	// query, command and query after command
+	assert.Equal(t, int32(3), fc.queries.Load())
+
+	r, err = e.control("unknown", func(internal.Client, internal.DeviceConnectionSpec) error {
+		t.Error("command sent to unknown device")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+}
+
+func TestFetchRetry(t *testing.T) {
+	refused := &internal.OpError{Op: internal.OpConnect, Err: syscall.ECONNREFUSED}
+	fc := &fakeClient{connectErrs: []error{refused, refused}}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+		Retry:   &internal.RetrySpec{Attempts: 3, Backoff: 20 * time.Millisecond, MaxBackoff: time.Second},
+	}, map[string]internal.Client{"dev1": fc})
+
+	r := e.fetch("dev1")
+	assert.NoError(t, r.err)
+	assert.Equal(t, int32(1), fc.queries.Load())
+	// backoff doubles with every attempt
+	assert.Len(t, fc.connects, 3)
+	assert.GreaterOrEqual(t, fc.connects[1].Sub(fc.connects[0]), 20*time.Millisecond)
+	assert.GreaterOrEqual(t, fc.connects[2].Sub(fc.connects[1]), 40*time.Millisecond)
+	ds, _ := e.snapshot("dev1")
+	assert.Empty(t, ds.errors)
+
+	// error is reported once attempts are exhausted
+	fc = &fakeClient{connectErrs: []error{refused, refused, refused, refused}}
+	e.clients["dev1"] = fc
+	r = e.fetch("dev1")
+	assert.ErrorIs(t, r.err, syscall.ECONNREFUSED)
+	assert.Equal(t, internal.ReasonConnectRefused, r.reason)
+	assert.Len(t, fc.connects, 4)
+	assert.Equal(t, int32(0), fc.queries.Load())
+}
+
+func TestStartDelay(t *testing.T) {
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{
+			"b": {Address: "127.0.0.2"},
+			"a": {Address: "127.0.0.1"},
+		},
+		Concurrency: &internal.ConcurrencySpec{MaxQueries: 1, Stagger: time.Second},
+	}, nil)
+	assert.Equal(t, time.Duration(0), e.startDelay("a"))
+	assert.Equal(t, time.Second, e.startDelay("b"))
+	assert.Equal(t, 1, cap(e.sem))
+}
+
+func TestStartDelayOnlyOnce(t *testing.T) {
+	fc := &fakeClient{}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{
+			"a": {Address: "127.0.0.1"},
+			"b": {Address: "127.0.0.2"},
+		},
+		Polling:     &internal.PollingSpec{Interval: 20 * time.Millisecond},
+		Concurrency: &internal.ConcurrencySpec{Stagger: 200 * time.Millisecond},
+	}, map[string]internal.Client{"a": &fakeClient{}, "b": fc})
+
+	// queries outside of poller or scrape, such as after MQTT command, are not delayed
+	start := time.Now()
+	e.fetch("b")
+	e.fetch("b")
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, int32(2), fc.queries.Load())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		e.pollDevice(ctx, "b")
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(2), fc.queries.Load())
+	// first poll is offset, subsequent polls follow interval
+	assert.Eventually(t, func() bool {
+		return fc.queries.Load() >= 3
+	}, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return fc.queries.Load() >= 6
+	}, 150*time.Millisecond, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestReload(t *testing.T) {
+	keep, change, drop := &fakeClient{}, &fakeClient{}, &fakeClient{}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{
+			"keep":   {Address: "127.0.0.1"},
+			"change": {Address: "127.0.0.2"},
+			"drop":   {Address: "127.0.0.3"},
+		},
+	}, map[string]internal.Client{"keep": keep, "change": change, "drop": drop})
+	e.fetch("keep")
+
+	e.Reload(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{
+			"keep":   {Address: "127.0.0.1"},
+			"change": {Address: "127.0.0.22"},
+			"new":    {Address: "127.0.0.4"},
+		},
+	})
+	assert.Len(t, e.clients, 3)
+	assert.Same(t, keep, e.clients["keep"])
+	assert.NotSame(t, change, e.clients["change"])
+	assert.NotNil(t, e.clients["new"])
+	assert.NotContains(t, e.clients, "drop")
+	_, ok := e.snapshot("keep")
+	assert.True(t, ok)
+}
+
+func TestReloadDoesNotWaitForQuery(t *testing.T) {
+	slow := &fakeClient{delay: time.Second}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"slow": {Address: "127.0.0.1"}},
+	}, map[string]internal.Client{"slow": slow})
+	done := make(chan *reading)
+	go func() {
+		done <- e.fetch("slow")
+	}()
+	assert.Eventually(t, func() bool {
+		return slow.queries.Load() == 1
+	}, time.Second, time.Millisecond)
+
+	start := time.Now()
+	e.Reload(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"slow": {Address: "127.0.0.2"}},
+	})
+	assert.Less(t, time.Since(start), slow.delay/2)
+	assert.NoError(t, (<-done).err)
+	// reading of client replaced by reload is not recorded
+	_, ok := e.snapshot("slow")
+	assert.False(t, ok)
+}
+
+func TestResolver(t *testing.T) {
+	// captured broadcast of device bfc4c2312693b32a4eucga at 192.168.1.127
+	beacon, _ := hex.DecodeString("000055aa0000000000000023000000bc00000000d09766676f3369eb10b5e9f1" +
+		"32fd802a2b8ecdb83424f7a6884b011d664ccd46eb00d00863ef3e4e2a06eae3f57b018cca322d15a3365719ab56ad0e" +
+		"3b4b29067256f992ef0bb3c9946f6ca8e2e148532e0dbc9a92c3317286ebf238be5797863e4a9d43c5263de269048b7c" +
+		"4f281c335cca9cf243b333079bed2b12b16ffb346fbdac3e9dd4c59ed6077a3713a1f534c5f75cc69c6a0e153160a17b" +
+		"c40acc9bf710aae30275845704b34769a7f2181a8d9f34800000aa55")
+	dc := internal.DeviceConnectionSpec{Id: "bfc4c2312693b32a4eucga", Address: "192.168.1.5:6000"}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices:   internal.DevicesContainer{"dev1": dc, "dev2": {Id: "unknown"}},
+		Discovery: &internal.DiscoverySpec{},
+	}, nil)
+	resolve := e.resolver("dev1", dc)
+	assert.Equal(t, "192.168.1.5:6000", resolve())
+	assert.NoError(t, e.disc.HandleDatagram(beacon))
+	assert.Equal(t, "192.168.1.127:6000", resolve())
+	assert.Equal(t, "192.168.1.127:6000", resolve())
+	assert.Equal(t, 1, e.state["dev1"].addressChanges)
+	// not discovered yet, nor configured
+	assert.Equal(t, "", e.resolver("dev2", e.cfg.Devices["dev2"])())
+	_, ok := e.snapshot("dev1")
+	assert.False(t, ok)
+}
+
+func TestStopDiscovery(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	assert.NoError(t, conn.Close())
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices:   internal.DevicesContainer{},
+		Discovery: &internal.DiscoverySpec{Listen: &[]string{addr}},
+	}, nil)
+	e.runCtx = t.Context()
+	for range 3 {
+		e.startDiscovery()
+		// listener needs some time to bind its socket
+		assert.Eventually(t, func() bool {
+			c, err := net.ListenPacket("udp", addr)
+			if err == nil {
+				_ = c.Close()
+			}
+			return err != nil
+		}, time.Second, time.Millisecond)
+		e.stopDiscovery()
+		// socket is released once stopDiscovery returns
+		c, err := net.ListenPacket("udp", addr)
+		assert.NoError(t, err)
+		_ = c.Close()
+	}
+}
+
+func TestProbe(t *testing.T) {
+	fc := &fakeClient{}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+		Credentials: &map[string]internal.CredentialsSpec{
+			"default": {Key: "0123456789abcdef", Protocol: "tuya3.1"},
+		},
+	}, map[string]internal.Client{"dev1": fc})
+
+	gather := func(q string) map[string]*dto.MetricFamily {
+		params, _ := url.ParseQuery(q)
+		g, err := e.Probe(params)
+		assert.NoError(t, err)
+		mfs, err := g.Gather()
+		assert.NoError(t, err)
+		return lo.KeyBy(mfs, func(mf *dto.MetricFamily) string {
+			return mf.GetName()
+		})
+	}
+	mfs := gather("device=dev1")
+	assert.Equal(t, 1.0, mfs["probe_success"].GetMetric()[0].GetGauge().GetValue())
+	assert.Equal(t, 10.0, mfs["tuya_smartplug_power"].GetMetric()[0].GetGauge().GetValue())
+	assert.Equal(t, int32(1), fc.queries.Load())
+
+	// nothing is listening there
+	mfs = gather("target=127.0.0.1:1&id=abc&profile=default")
+	assert.Equal(t, 0.0, mfs["probe_success"].GetMetric()[0].GetGauge().GetValue())
+	assert.Equal(t, 1.0, mfs["tuya_smartplug_scrape_errors_total"].GetMetric()[0].GetCounter().GetValue())
+	assert.NotContains(t, mfs, "tuya_smartplug_power")
+
+	for _, q := range []string{"", "device=unknown", "target=127.0.0.1", "target=127.0.0.1&id=abc&credentials=other",
+		"target=127.0.0.1&id=abc&profile=toaster"} {
+		params, _ := url.ParseQuery(q)
+		_, err := e.Probe(params)
+		assert.Error(t, err, q)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{
+			"b": {Address: "127.0.0.2", ExtraLabels: &map[string]string{"tld.acme/room": "kitchen"}},
+			"a": {Address: "127.0.0.1", ExtraLabels: &map[string]string{"tld.acme/room": "garage"}},
+		},
+		ExtraDeviceLabels: &internal.ExtraDeviceLabels{"tld.acme/room"},
+	}, map[string]internal.Client{"a": &fakeClient{}, "b": &fakeClient{}})
+	assert.Equal(t, []TargetGroup{
+		{Targets: []string{"a"}, Labels: map[string]string{"__meta_tuya_device": "a", "__meta_tuya_address": "127.0.0.1",
+			"__meta_tuya_label_tld_acme_room": "garage"}},
+		{Targets: []string{"b"}, Labels: map[string]string{"__meta_tuya_device": "b", "__meta_tuya_address": "127.0.0.2",
+			"__meta_tuya_label_tld_acme_room": "kitchen"}},
+	}, e.Targets())
+
+	e.Reload(&internal.ConfigSpec{Devices: internal.DevicesContainer{"c": {Address: "127.0.0.3"}}})
+	assert.Equal(t, []TargetGroup{
+		{Targets: []string{"c"}, Labels: map[string]string{"__meta_tuya_device": "c", "__meta_tuya_address": "127.0.0.3"}},
+	}, e.Targets())
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+)
+
+const (
+	sinkInfluxdb = "influxdb"
+
+	defaultMeasurement       = "tuya_smartplug"
+	defaultInfluxBatchSize   = 500
+	defaultInfluxFlush       = time.Second
+	defaultInfluxQueueSize   = 10000
+	defaultInfluxTimeout     = 10 * time.Second
+	defaultInfluxBackoff     = time.Second
+	defaultInfluxMaxBackoff  = time.Minute
+	maxInfluxErrorBodyLength = 512
+	influxContentType        = "text/plain; charset=utf-8"
+	influxTimestampPrecision = "ms"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// permanentError is write error that won't go away when retried, such as malformed request.
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanentError) Unwrap() error {
+	return p.err
+}
+
+// influxSink writes queued points to InfluxDB v2 write API in batches.
+type influxSink struct {
+	spec   internal.InfluxdbSpec
+	client *http.Client
+	m      GlobalMetrics
+	l      *slog.Logger
+	cancel context.CancelFunc
+	// closed once run returns
+	done chan struct{}
+	// signalled when points are added to queue
+	notify chan struct{}
+	lock   sync.Mutex
+	queue  []string
+}
+
+func (e *exporter) influxEnabled() bool {
+	return e.cfg.Influxdb != nil
+}
+
+// influxSpec returns InfluxDB settings with defaults applied.
+func (e *exporter) influxSpec() internal.InfluxdbSpec {
+	spec := *e.cfg.Influxdb
+	spec.Measurement = lo.CoalesceOrEmpty(spec.Measurement, defaultMeasurement)
+	spec.BatchSize = lo.CoalesceOrEmpty(spec.BatchSize, defaultInfluxBatchSize)
+	spec.FlushInterval = lo.CoalesceOrEmpty(spec.FlushInterval, defaultInfluxFlush)
+	spec.QueueSize = lo.CoalesceOrEmpty(spec.QueueSize, defaultInfluxQueueSize)
+	spec.Timeout = lo.CoalesceOrEmpty(spec.Timeout, defaultInfluxTimeout)
+	spec.Backoff = lo.CoalesceOrEmpty(spec.Backoff, defaultInfluxBackoff)
+	spec.MaxBackoff = lo.CoalesceOrEmpty(spec.MaxBackoff, defaultInfluxMaxBackoff)
+	return spec
+}
+
+// startInflux starts writing readings to InfluxDB, if it's enabled and sink is not running yet.
+// Caller must hold write lock.
+func (e *exporter) startInflux() {
+	if e.runCtx == nil || !e.influxEnabled() || e.influx != nil {
+		return
+	}
+	spec := e.influxSpec()
+	ctx, cancel := context.WithCancel(e.runCtx)
+	e.influx = &influxSink{
+		spec:   spec,
+		client: &http.Client{Timeout: spec.Timeout},
+		m:      e.m,
+		l:      e.l.With("sink", sinkInfluxdb),
+		cancel: cancel,
+		done:   make(chan struct{}),
+		notify: make(chan struct{}, 1),
+	}
+	e.l.Info("writing readings to InfluxDB", "url", spec.Url, "org", spec.Org, "bucket", spec.Bucket)
+	go e.influx.run(ctx)
+}
+
+// stopInflux stops writing to InfluxDB. Points still in queue are written once more, without retry.
+// Returned function waits for that write, it should be called after releasing write lock.
+// Caller must hold write lock.
+func (e *exporter) stopInflux() func() {
+	s := e.influx
+	if s == nil {
+		return func() {}
+	}
+	s.cancel()
+	e.influx = nil
+	return func() {
+		<-s.done
+	}
+}
+
+// influxLine formats successful reading of device as single point in line protocol.
+// Caller must hold read lock.
+func (e *exporter) influxLine(measurement, dname string, r *reading) string {
+	dc := e.cfg.Devices[dname]
+	tags := map[string]string{"device": dname}
+	for _, ln := range e.cfg.ExtraLabelNames() {
+		// empty tag values are not allowed
+		if v := lo.FromPtr(dc.ExtraLabels)[ln]; v != "" {
+			tags[e.cfg.ExportedLabelName(ln)] = v
+		}
+	}
+	pr := r.plug
+	var sb strings.Builder
+	sb.WriteString(measurementEscaper.Replace(measurement))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		sb.WriteString("," + tagEscaper.Replace(k) + "=" + tagEscaper.Replace(tags[k]))
+	}
+	sb.WriteString(" current=" + strconv.FormatFloat(pr.Current, 'f', -1, 64))
+	sb.WriteString(",power=" + strconv.FormatFloat(pr.Power, 'f', -1, 64))
+	sb.WriteString(",switch_on=" + strconv.FormatBool(pr.SwitchOn))
+	sb.WriteString(",voltage=" + strconv.FormatFloat(pr.Voltage, 'f', -1, 64))
+	sb.WriteString(" " + strconv.FormatInt(r.at.UnixMilli(), 10))
+	return sb.String()
+}
+
+// pushReading queues reading of device for configured sinks. Failed readings are not pushed.
+// Caller must hold read lock.
+func (e *exporter) pushReading(dname string, r *reading) {
+	if r.err != nil {
+		return
+	}
+	if e.influx != nil {
+		e.influx.push(e.influxLine(e.influx.spec.Measurement, dname, r))
+	}
+}
+
+// push adds points to queue, dropping the oldest ones when queue is full.
+func (s *influxSink) push(lines ...string) {
+	s.lock.Lock()
+	s.queue = append(s.queue, lines...)
+	if over := len(s.queue) - s.spec.QueueSize; over > 0 {
+		s.queue = s.queue[over:]
+		s.m.SinkDropped.WithLabelValues(sinkInfluxdb).Add(float64(over))
+		s.l.Debug("queue is full, dropping oldest points", "dropped", over)
+	}
+	s.m.SinkQueueLength.WithLabelValues(sinkInfluxdb).Set(float64(len(s.queue)))
+	s.lock.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes up to batch size points from head of queue.
+func (s *influxSink) pop() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := min(len(s.queue), s.spec.BatchSize)
+	batch := slices.Clone(s.queue[:n])
+	s.queue = s.queue[n:]
+	s.m.SinkQueueLength.WithLabelValues(sinkInfluxdb).Set(float64(len(s.queue)))
+	return batch
+}
+
+// requeue puts batch which failed to be written back to head of queue.
+func (s *influxSink) requeue(batch []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queue = append(batch, s.queue...)
+	if over := len(s.queue) - s.spec.QueueSize; over > 0 {
+		s.queue = s.queue[over:]
+		s.m.SinkDropped.WithLabelValues(sinkInfluxdb).Add(float64(over))
+	}
+	s.m.SinkQueueLength.WithLabelValues(sinkInfluxdb).Set(float64(len(s.queue)))
+}
+
+func (s *influxSink) pending() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.queue)
+}
+
+func (s *influxSink) run(ctx context.Context) {
+	defer close(s.done)
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			s.flush()
+			return
+		case <-s.notify:
+		}
+		// wait for remaining readings of the same poll
+		if s.pending() < s.spec.BatchSize {
+			select {
+			case <-ctx.Done():
+				s.flush()
+				return
+			case <-time.After(s.spec.FlushInterval):
+			}
+		}
+		for s.pending() > 0 {
+			batch := s.pop()
+			err := s.write(ctx, batch)
+			if err == nil {
+				failures = 0
+				continue
+			}
+			s.m.SinkFailures.WithLabelValues(sinkInfluxdb).Inc()
+			var perr *permanentError
+			if errors.As(err, &perr) {
+				s.l.Error("write rejected, dropping points", "points", len(batch), "error", err)
+				s.m.SinkDropped.WithLabelValues(sinkInfluxdb).Add(float64(len(batch)))
+				continue
+			}
+			s.requeue(batch)
+			backoff := s.backoff(failures)
+			failures++
+			s.l.Warn("write failed, will retry", "points", len(batch), "backoff", backoff, "error", err)
+			select {
+			case <-ctx.Done():
+				s.flush()
+				return
+			case <-time.After(backoff):
+			}
+		}
+	}
+}
+
+// flush makes single attempt to write all queued points, it's used when sink is stopped.
+func (s *influxSink) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.spec.Timeout)
+	defer cancel()
+	for s.pending() > 0 {
+		batch := s.pop()
+		if err := s.write(ctx, batch); err != nil {
+			s.m.SinkFailures.WithLabelValues(sinkInfluxdb).Inc()
+			s.m.SinkDropped.WithLabelValues(sinkInfluxdb).Add(float64(len(batch) + s.pending()))
+			s.l.Warn("unable to write remaining points", "error", err)
+			return
+		}
+	}
+}
+
+func (s *influxSink) backoff(failures int) time.Duration {
+	d := s.spec.Backoff
+	for i := 0; i < failures && d < s.spec.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, s.spec.MaxBackoff)
+}
+
+// write sends batch of points to write API.
+func (s *influxSink) write(ctx context.Context, batch []string) error {
+	u, err := url.JoinPath(s.spec.Url, "/api/v2/write")
+	if err != nil {
+		return &permanentError{err}
+	}
+	u += "?" + url.Values{
+		"org":       {s.spec.Org},
+		"bucket":    {s.spec.Bucket},
+		"precision": {influxTimestampPrecision},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(strings.Join(batch, "\n")))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", influxContentType)
+	if s.spec.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.spec.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		s.m.SinkWritten.WithLabelValues(sinkInfluxdb).Add(float64(len(batch)))
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxInfluxErrorBodyLength))
+	err = fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+	// client errors other than rate limiting are not retried
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout {
+		return &permanentError{err}
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfluxLine(t *testing.T) {
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{
+			"living room": {Address: "127.0.0.1", ExtraLabels: &map[string]string{"room": "a,b=c"}},
+		},
+		ExtraDeviceLabels: &internal.ExtraDeviceLabels{"room", "floor"},
+	}, nil)
+	r := &reading{
+		status: &internal.DpQueryResponse{Dps: map[string]any{"1": false, "18": 50.0, "19": 100.0, "20": 2300.0}},
+		plug:   internal.PlugReading{Current: 0.05, Power: 10, Voltage: 230},
+		at:     time.UnixMilli(1700000000123),
+	}
+	assert.Equal(t, `plugs\ and\,more,device=living\ room,room=a\,b\=c current=0.05,power=10,switch_on=false,voltage=230 1700000000123`,
+		e.influxLine("plugs and,more", "living room", r))
+}
+
+func TestInflux(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		requests int
+		body     string
+		query    string
+		auth     string
+	)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		// first write fails with transient error, second one is retried successfully
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		body, query, auth = string(data), r.URL.RawQuery, r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer stub.Close()
+
+	fc := &fakeClient{}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+		Influxdb: &internal.InfluxdbSpec{
+			Url:           stub.URL,
+			Org:           "home",
+			Bucket:        "plugs",
+			Token:         "secret",
+			FlushInterval: 10 * time.Millisecond,
+			Backoff:       10 * time.Millisecond,
+		},
+	}, map[string]internal.Client{"dev1": fc})
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		e.cfgLock.RLock()
+		defer e.cfgLock.RUnlock()
+		return e.influx != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	r := e.fetch("dev1")
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(e.m.SinkWritten.WithLabelValues(sinkInfluxdb)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, "tuya_smartplug,device=dev1 current=0.05,power=10,switch_on=true,voltage=230 "+
+		strconv.FormatInt(r.at.UnixMilli(), 10), body)
+	assert.Equal(t, "bucket=plugs&org=home&precision=ms", query)
+	assert.Equal(t, "Token secret", auth)
+	lock.Unlock()
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.m.SinkFailures.WithLabelValues(sinkInfluxdb)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(e.m.SinkQueueLength.WithLabelValues(sinkInfluxdb)))
+	cancel()
+	<-done
+}
+
+func TestInfluxRejected(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"code":"invalid","message":"unable to parse"}`, http.StatusBadRequest)
+	}))
+	defer stub.Close()
+	m := newCommonMetrics()
+	s := &influxSink{
+		spec:   internal.InfluxdbSpec{Url: stub.URL, BatchSize: 2, QueueSize: 3},
+		client: stub.Client(),
+		m:      m,
+		l:      slog.New(slog.DiscardHandler),
+		notify: make(chan struct{}, 1),
+	}
+	s.push("a", "b", "c", "d")
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.SinkDropped.WithLabelValues(sinkInfluxdb)))
+	assert.Equal(t, []string{"b", "c"}, s.pop())
+	err := s.write(t.Context(), []string{"b", "c"})
+	var perr *permanentError
+	assert.ErrorAs(t, err, &perr)
+	assert.ErrorContains(t, err, "unable to parse")
+}
+
+func TestInfluxFlushOnShutdown(t *testing.T) {
+	var (
+		lock sync.Mutex
+		body string
+	)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer stub.Close()
+
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+		Influxdb: &internal.InfluxdbSpec{
+			Url:    stub.URL,
+			Org:    "home",
+			Bucket: "plugs",
+			// queued points are only written by flush
+			FlushInterval: time.Hour,
+		},
+	}, map[string]internal.Client{"dev1": &fakeClient{}})
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		e.cfgLock.RLock()
+		defer e.cfgLock.RUnlock()
+		return e.influx != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e.fetch("dev1")
+	cancel()
+	<-done
+	// Run returns only after remaining points were written
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Contains(t, body, "tuya_smartplug,device=dev1 ")
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.m.SinkWritten.WithLabelValues(sinkInfluxdb)))
+}
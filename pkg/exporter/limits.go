@@ -0,0 +1,86 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+)
+
+const (
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+func (e *exporter) setupLimits() {
+	e.sem = nil
+	e.order = nil
+	if e.cfg.Concurrency == nil {
+		return
+	}
+	if e.cfg.Concurrency.MaxQueries > 0 {
+		e.sem = make(chan struct{}, e.cfg.Concurrency.MaxQueries)
+	}
+	names := lo.Keys(e.cfg.Devices)
+	slices.Sort(names)
+	e.order = make(map[string]int, len(names))
+	for i, name := range names {
+		e.order[name] = i
+	}
+}
+
+// startDelay computes how long to wait before querying device, based on its position and configured jitter.
+func (e *exporter) startDelay(dname string) time.Duration {
+	if e.cfg.Concurrency == nil {
+		return 0
+	}
+	d := time.Duration(e.order[dname]) * e.cfg.Concurrency.Stagger
+	if e.cfg.Concurrency.Jitter > 0 {
+		d += rand.N(e.cfg.Concurrency.Jitter)
+	}
+	return d
+}
+
+// acquire waits for free query slot of given semaphore. Returned function must be called to release the slot.
+func acquire(sem chan struct{}) func() {
+	if sem == nil {
+		return func() {}
+	}
+	sem <- struct{}{}
+	return func() {
+		<-sem
+	}
+}
+
+// retryBackoff computes delay before next attempt, doubled with every attempt and capped.
+func retryBackoff(spec *internal.RetrySpec, attempt int) time.Duration {
+	d, maxD := defaultRetryBackoff, defaultRetryMaxBackoff
+	if spec.Backoff > 0 {
+		d = spec.Backoff
+	}
+	if spec.MaxBackoff > 0 {
+		maxD = spec.MaxBackoff
+	}
+	for i := 0; i < attempt && d < maxD; i++ {
+		d *= 2
+	}
+	return min(d, maxD)
+}
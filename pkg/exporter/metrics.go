@@ -17,6 +17,8 @@ limitations under the License.
 package exporter
 
 import (
+	"slices"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -27,24 +29,19 @@ const (
 
 func (e *exporter) newDeviceMetrics() DeviceMetrics {
 	devLabels := []string{"device"}
-	if e.cfg.ExtraDeviceLabels != nil {
-		for _, ln := range *e.cfg.ExtraDeviceLabels {
-			devLabels = append(devLabels, ln)
-		}
+	for _, ln := range e.cfg.ExtraLabelNames() {
+		devLabels = append(devLabels, e.cfg.ExportedLabelName(ln))
 	}
 	return DeviceMetrics{
-		ScrapeDuration: prometheus.NewSummaryVec(prometheus.SummaryOpts{
-			Namespace: namespace,
-			Subsystem: subsystem,
-			Name:      "scrape_duration",
-			Help:      "Summary of scrape operation",
-		}, devLabels),
+		// observed once per device query rather than on every collection, so it's emitted from totals kept in device state
+		ScrapeDuration: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "scrape_duration"),
+			"Summary of scrape operation", devLabels, nil),
 		ScrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "scrape_errors_total",
-			Help:      "Total number of times an error occurred while scraping",
-		}, devLabels),
+			Help:      "Total number of times an error occurred while scraping, by reason",
+		}, append(slices.Clone(devLabels), "reason")),
 		Current: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
@@ -93,10 +90,29 @@ func (e *exporter) newDeviceMetrics() DeviceMetrics {
 			Name:      "read_errors_total",
 			Help:      "Total number of read errors",
 		}, devLabels),
+		ReadingAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "reading_age_seconds",
+			Help:      "Age of last successful reading of device, in seconds. Only present in polling mode.",
+		}, devLabels),
+		BreakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "circuit_breaker_state",
+			Help:      "State of device circuit breaker (0 for closed, 1 for open, 2 for half-open).",
+		}, devLabels),
+		AddressChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "address_changes_total",
+			Help:      "Total number of times device address changed. Only present when discovery is enabled.",
+		}, devLabels),
 	}
 }
 
 func newCommonMetrics() GlobalMetrics {
+	sinkLabels := []string{"sink"}
 	return GlobalMetrics{
 		// since devices are scraped in parallel, this metric captures overall duration
 		TotalScrapes: prometheus.NewSummary(prometheus.SummaryOpts{
@@ -111,5 +127,30 @@ func newCommonMetrics() GlobalMetrics {
 			Name:      "last_scrape_error",
 			Help:      "Whether the last scrape of metrics resulted in an error (1 for error, 0 for success).",
 		}),
+		// push sinks share these, distinguished by sink label
+		SinkQueueLength: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "sink_queue_length",
+			Help:      "Number of points waiting to be pushed, by sink",
+		}, sinkLabels),
+		SinkWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "sink_points_written_total",
+			Help:      "Total number of points pushed successfully, by sink",
+		}, sinkLabels),
+		SinkDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "sink_points_dropped_total",
+			Help:      "Total number of points dropped because queue was full or write was rejected, by sink",
+		}, sinkLabels),
+		SinkFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "sink_write_failures_total",
+			Help:      "Total number of failed write attempts, by sink",
+		}, sinkLabels),
 	}
 }
@@ -0,0 +1,514 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+)
+
+const (
+	defaultMqttClientId     = "tuya-smartplug-exporter"
+	defaultTopicPrefix      = "tuya"
+	defaultDiscoveryPrefix  = "homeassistant"
+	mqttTimeout             = 10 * time.Second
+	payloadOnline           = "online"
+	payloadOffline          = "offline"
+	payloadOn               = "ON"
+	payloadOff              = "OFF"
+	switchCommandTopicLevel = "switch/set"
+)
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// mqttConn is connection to MQTT broker, as used by publisher.
+type mqttConn interface {
+	Publish(topic string, payload []byte, retain bool) error
+	Subscribe(topic string, handler func(topic string, payload []byte)) error
+	Close()
+}
+
+// mqttDialer connects to broker. Connection is re-established automatically, onConnect is called after every connection.
+type mqttDialer func(spec internal.MqttSpec, will mqttMessage, onConnect func(mqttConn), l *slog.Logger) (mqttConn, error)
+
+type pahoConn struct {
+	c   paho.Client
+	qos byte
+}
+
+func (p *pahoConn) wait(t paho.Token) error {
+	if !t.WaitTimeout(mqttTimeout) {
+		return errors.New("timeout waiting for broker")
+	}
+	return t.Error()
+}
+
+func (p *pahoConn) Publish(topic string, payload []byte, retain bool) error {
+	return p.wait(p.c.Publish(topic, p.qos, retain, payload))
+}
+
+func (p *pahoConn) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	return p.wait(p.c.Subscribe(topic, p.qos, func(_ paho.Client, msg paho.Message) {
+		handler(msg.Topic(), msg.Payload())
+	}))
+}
+
+func (p *pahoConn) Close() {
+	p.c.Disconnect(uint(time.Second / time.Millisecond))
+}
+
+// dialPaho connects to broker using Eclipse Paho client. Initial connection is retried in background.
+func dialPaho(spec internal.MqttSpec, will mqttMessage, onConnect func(mqttConn), l *slog.Logger) (mqttConn, error) {
+	conn := &pahoConn{qos: byte(spec.Qos)}
+	opts := paho.NewClientOptions().
+		AddBroker(spec.Broker).
+		SetClientID(spec.ClientId).
+		SetUsername(spec.Username).
+		SetPassword(spec.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetWill(will.topic, string(will.payload), conn.qos, will.retain).
+		SetOnConnectHandler(func(paho.Client) {
+			l.Info("connected to MQTT broker", "broker", spec.Broker)
+			onConnect(conn)
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			l.Warn("connection to MQTT broker lost", "broker", spec.Broker, "error", err)
+		})
+	conn.c = paho.NewClient(opts)
+	// with connect retry enabled, token completes only once connected, so it's not waited for
+	t := conn.c.Connect()
+	select {
+	case <-t.Done():
+		if err := t.Error(); err != nil {
+			return nil, err
+		}
+	default:
+	}
+	return conn, nil
+}
+
+// haDevice is device section of Home Assistant discovery message.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// haConfig is Home Assistant discovery message of single entity.
+type haConfig struct {
+	// nil name makes entity use name of device
+	Name              *string          `json:"name"`
+	UniqueId          string           `json:"unique_id"`
+	StateTopic        string           `json:"state_topic"`
+	ValueTemplate     string           `json:"value_template"`
+	CommandTopic      string           `json:"command_topic,omitempty"`
+	PayloadOn         string           `json:"payload_on,omitempty"`
+	PayloadOff        string           `json:"payload_off,omitempty"`
+	DeviceClass       string           `json:"device_class,omitempty"`
+	StateClass        string           `json:"state_class,omitempty"`
+	UnitOfMeasurement string           `json:"unit_of_measurement,omitempty"`
+	Availability      []haAvailability `json:"availability"`
+	AvailabilityMode  string           `json:"availability_mode"`
+	Device            haDevice         `json:"device"`
+}
+
+// plugState is payload of state topic.
+type plugState struct {
+	Switch  string  `json:"switch"`
+	Current float64 `json:"current"`
+	Power   float64 `json:"power"`
+	Voltage float64 `json:"voltage"`
+}
+
+// sensors published for every device: key in state payload, name, device class and unit
+var haSensors = [][4]string{
+	{"current", "Current", "current", "A"},
+	{"power", "Power", "power", "W"},
+	{"voltage", "Voltage", "voltage", "V"},
+}
+
+// publisher periodically publishes readings of devices to MQTT and handles switch commands.
+type publisher struct {
+	e      *exporter
+	spec   internal.MqttSpec
+	conn   mqttConn
+	cancel context.CancelFunc
+	// closed once run returns
+	done chan struct{}
+	// wakes up publishing loop outside of regular interval
+	wake chan struct{}
+	lock sync.Mutex
+	// discovery topics published per device, used to remove devices that are no longer configured.
+	// Shared with publishers started later, so that devices removed while restarting are removed from Home Assistant too
+	announced map[string][]string
+	// all devices must be announced again, since Home Assistant restarted
+	reannounce bool
+}
+
+func (e *exporter) mqttEnabled() bool {
+	return e.cfg.Mqtt != nil
+}
+
+// mqttSpec returns MQTT settings with defaults applied.
+func (e *exporter) mqttSpec() internal.MqttSpec {
+	spec := *e.cfg.Mqtt
+	spec.ClientId = lo.CoalesceOrEmpty(spec.ClientId, defaultMqttClientId)
+	spec.TopicPrefix = lo.CoalesceOrEmpty(spec.TopicPrefix, defaultTopicPrefix)
+	spec.DiscoveryPrefix = lo.CoalesceOrEmpty(spec.DiscoveryPrefix, defaultDiscoveryPrefix)
+	spec.Interval = e.pushInterval(spec.Interval)
+	return spec
+}
+
+// startMqtt connects to broker and starts publishing, if MQTT is enabled and publisher is not running yet.
+// Caller must hold write lock.
+func (e *exporter) startMqtt() {
+	if e.runCtx == nil || !e.mqttEnabled() || e.mqtt != nil {
+		return
+	}
+	p := &publisher{
+		e:         e,
+		spec:      e.mqttSpec(),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		announced: e.mqttAnnounced,
+	}
+	will := mqttMessage{topic: p.statusTopic(), payload: []byte(payloadOffline), retain: true}
+	conn, err := e.dialMqtt(p.spec, will, p.connected, e.l)
+	if err != nil {
+		e.l.Error("unable to connect to MQTT broker", "broker", p.spec.Broker, "error", err)
+		return
+	}
+	p.conn = conn
+	ctx, cancel := context.WithCancel(e.runCtx)
+	p.cancel = cancel
+	e.mqtt = p
+	e.l.Info("publishing to MQTT broker", "broker", p.spec.Broker, "interval", p.spec.Interval)
+	go p.run(ctx)
+}
+
+// stopMqtt stops publishing. Returned function waits for publishing in progress, publishes offline status
+// and disconnects from broker, it should be called after releasing write lock.
+// Caller must hold write lock.
+func (e *exporter) stopMqtt() func() {
+	p := e.mqtt
+	if p == nil {
+		return func() {}
+	}
+	p.cancel()
+	e.mqtt = nil
+	return func() {
+		<-p.done
+		_ = p.conn.Publish(p.statusTopic(), []byte(payloadOffline), true)
+		p.conn.Close()
+	}
+}
+
+// topicName converts device name into single topic level.
+func topicName(dname string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_").Replace(dname)
+}
+
+func (p *publisher) statusTopic() string {
+	return p.spec.TopicPrefix + "/status"
+}
+
+func (p *publisher) deviceTopic(dname, suffix string) string {
+	return p.spec.TopicPrefix + "/" + topicName(dname) + "/" + suffix
+}
+
+// connected subscribes to command topics and announces availability, it's called after every connection.
+func (p *publisher) connected(conn mqttConn) {
+	l := p.e.l
+	err := conn.Subscribe(p.spec.TopicPrefix+"/+/"+switchCommandTopicLevel, func(topic string, payload []byte) {
+		p.command(conn, topic, payload)
+	})
+	if err != nil {
+		l.Error("unable to subscribe to command topics", "error", err)
+	}
+	if err := conn.Subscribe(p.spec.DiscoveryPrefix+"/status", p.homeAssistantStatus); err != nil {
+		l.Error("unable to subscribe to Home Assistant status", "error", err)
+	}
+	if err := conn.Publish(p.statusTopic(), []byte(payloadOnline), true); err != nil {
+		l.Warn("unable to publish status", "error", err)
+	}
+	p.refresh()
+}
+
+// refresh makes publisher announce all devices again and publish their state without waiting for next interval.
+func (p *publisher) refresh() {
+	p.lock.Lock()
+	p.reannounce = true
+	p.lock.Unlock()
+	p.nudge()
+}
+
+// nudge makes publisher publish without waiting for next interval.
+func (p *publisher) nudge() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *publisher) homeAssistantStatus(_ string, payload []byte) {
+	if string(payload) == payloadOnline {
+		p.e.l.Debug("Home Assistant came online, announcing devices")
+		p.refresh()
+	}
+}
+
+func (p *publisher) run(ctx context.Context) {
+	defer close(p.done)
+	t := time.NewTicker(p.spec.Interval)
+	defer t.Stop()
+	for {
+		p.publishAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// publishAll announces devices and publishes their current state.
+func (p *publisher) publishAll(ctx context.Context) {
+	msgs := p.collect(ctx)
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := p.conn.Publish(msg.topic, msg.payload, msg.retain); err != nil {
+			p.e.l.Warn("unable to publish message", "topic", msg.topic, "error", err)
+		}
+	}
+}
+
+// collect builds messages to publish: discovery messages of new and removed devices, followed by device states.
+func (p *publisher) collect(ctx context.Context) []mqttMessage {
+	e := p.e
+	e.cfgLock.RLock()
+	// publisher might have been stopped while waiting for lock
+	if ctx.Err() != nil {
+		e.cfgLock.RUnlock()
+		return nil
+	}
+	msgs := p.announce()
+	names := slices.Collect(maps.Keys(e.cfg.Devices))
+	polling := e.polling()
+	e.cfgLock.RUnlock()
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, dname := range names {
+		wg.Go(func() {
+			if !polling {
+				e.fetch(dname)
+			}
+			e.cfgLock.RLock()
+			m := p.stateMessages(dname)
+			e.cfgLock.RUnlock()
+			lock.Lock()
+			defer lock.Unlock()
+			msgs = append(msgs, m...)
+		})
+	}
+	wg.Wait()
+	return msgs
+}
+
+// announce returns discovery messages of devices that were not announced yet and removals of devices that are gone.
+// Caller must hold read lock.
+func (p *publisher) announce() []mqttMessage {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var msgs []mqttMessage
+	for _, dname := range slices.Sorted(maps.Keys(p.e.cfg.Devices)) {
+		cfgs := p.discoveryMessages(dname, p.e.cfg.Devices[dname])
+		topics := lo.Map(cfgs, func(m mqttMessage, _ int) string { return m.topic })
+		prev, ok := p.announced[dname]
+		if ok && !p.reannounce && slices.Equal(prev, topics) {
+			continue
+		}
+		// id of device might have changed
+		for _, topic := range lo.Without(prev, topics...) {
+			msgs = append(msgs, mqttMessage{topic: topic, retain: true})
+		}
+		msgs = append(msgs, cfgs...)
+		p.announced[dname] = topics
+	}
+	for dname, topics := range p.announced {
+		if _, ok := p.e.cfg.Devices[dname]; ok {
+			continue
+		}
+		// empty retained message removes entity from Home Assistant
+		for _, topic := range topics {
+			msgs = append(msgs, mqttMessage{topic: topic, retain: true})
+		}
+		delete(p.announced, dname)
+	}
+	p.reannounce = false
+	return msgs
+}
+
+// discoveryMessages returns Home Assistant discovery messages of device: switch and one sensor per reading.
+func (p *publisher) discoveryMessages(dname string, dc internal.DeviceConnectionSpec) []mqttMessage {
+	objectId := "tuya_" + dc.Id
+	base := haConfig{
+		StateTopic:       p.deviceTopic(dname, "state"),
+		Availability:     []haAvailability{{Topic: p.statusTopic()}, {Topic: p.deviceTopic(dname, "availability")}},
+		AvailabilityMode: "all",
+		Device: haDevice{
+			Identifiers:  []string{objectId},
+			Name:         dname,
+			Manufacturer: "Tuya",
+		},
+	}
+	sw := base
+	sw.UniqueId = objectId + "_switch"
+	sw.ValueTemplate = "{{ value_json.switch }}"
+	sw.CommandTopic = p.deviceTopic(dname, switchCommandTopicLevel)
+	sw.PayloadOn = payloadOn
+	sw.PayloadOff = payloadOff
+	sw.DeviceClass = "outlet"
+	msgs := []mqttMessage{p.discoveryMessage("switch", objectId, "switch", sw)}
+	for _, s := range haSensors {
+		c := base
+		c.Name = new(s[1])
+		c.UniqueId = objectId + "_" + s[0]
+		c.ValueTemplate = fmt.Sprintf("{{ value_json.%s }}", s[0])
+		c.DeviceClass = s[2]
+		c.StateClass = "measurement"
+		c.UnitOfMeasurement = s[3]
+		msgs = append(msgs, p.discoveryMessage("sensor", objectId, s[0], c))
+	}
+	return msgs
+}
+
+func (p *publisher) discoveryMessage(component, objectId, key string, c haConfig) mqttMessage {
+	payload, _ := json.Marshal(c)
+	return mqttMessage{
+		topic:   fmt.Sprintf("%s/%s/%s/%s/config", p.spec.DiscoveryPrefix, component, objectId, key),
+		payload: payload,
+		retain:  true,
+	}
+}
+
+// stateMessages returns availability of device and its state, if there is reading to report.
+// Caller must hold read lock.
+func (p *publisher) stateMessages(dname string) []mqttMessage {
+	avail := mqttMessage{topic: p.deviceTopic(dname, "availability"), payload: []byte(payloadOffline), retain: true}
+	ds, ok := p.e.snapshot(dname)
+	if !ok {
+		return []mqttMessage{avail}
+	}
+	good := p.e.goodReading(dname, ds)
+	if good == nil {
+		return []mqttMessage{avail}
+	}
+	pr := good.plug
+	state := plugState{
+		Switch:  lo.Ternary(pr.SwitchOn, payloadOn, payloadOff),
+		Current: pr.Current,
+		Power:   pr.Power,
+		Voltage: pr.Voltage,
+	}
+	payload, _ := json.Marshal(state)
+	avail.payload = []byte(payloadOnline)
+	return []mqttMessage{
+		{topic: p.deviceTopic(dname, "state"), payload: payload, retain: p.spec.Retain},
+		avail,
+	}
+}
+
+// command handles message on command topic of device, switching it on or off.
+func (p *publisher) command(conn mqttConn, topic string, payload []byte) {
+	e := p.e
+	name := strings.TrimSuffix(strings.TrimPrefix(topic, p.spec.TopicPrefix+"/"), "/"+switchCommandTopicLevel)
+	var on bool
+	switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+	case payloadOn:
+		on = true
+	case payloadOff:
+	default:
+		e.l.Warn("ignoring invalid switch command", "topic", topic, "payload", string(payload))
+		return
+	}
+	msgs, err := p.setSwitch(name, on)
+	if err != nil {
+		e.l.Warn("unable to switch device", "topic", topic, "on", on, "error", err)
+		return
+	}
+	for _, msg := range msgs {
+		if err = conn.Publish(msg.topic, msg.payload, msg.retain); err != nil {
+			e.l.Warn("unable to publish message", "topic", msg.topic, "error", err)
+		}
+	}
+}
+
+// setSwitch switches device which topic level matches given name, then queries device for its new state.
+func (p *publisher) setSwitch(name string, on bool) ([]mqttMessage, error) {
+	e := p.e
+	e.cfgLock.RLock()
+	dname, ok := lo.Find(slices.Sorted(maps.Keys(e.cfg.Devices)), func(dname string) bool {
+		return topicName(dname) == name
+	})
+	if !ok {
+		e.cfgLock.RUnlock()
+		return nil, fmt.Errorf("unknown device '%s'", name)
+	}
+	e.cfgLock.RUnlock()
+	r, err := e.control(dname, func(cl internal.Client, dc internal.DeviceConnectionSpec) error {
+		profile, _ := internal.LookupProfile(dc.Profile)
+		return internal.SetDps(cl, dc, map[string]any{profile.SwitchDp: on})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		// removed by reload in the meantime
+		return nil, nil
+	}
+	e.l.Info("device switched", "device", dname, "on", on)
+	e.cfgLock.RLock()
+	defer e.cfgLock.RUnlock()
+	return p.stateMessages(dname), nil
+}
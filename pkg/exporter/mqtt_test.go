@@ -0,0 +1,216 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rkosegi/tuya-proto/proto"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMqtt struct {
+	lock      sync.Mutex
+	published map[string]string
+	subs      map[string]func(topic string, payload []byte)
+	closed    bool
+}
+
+func (f *fakeMqtt) Publish(topic string, payload []byte, _ bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.published[topic] = string(payload)
+	return nil
+}
+
+func (f *fakeMqtt) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.subs[topic] = handler
+	return nil
+}
+
+func (f *fakeMqtt) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+}
+
+func (f *fakeMqtt) message(topic string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	payload, ok := f.published[topic]
+	return payload, ok
+}
+
+func (f *fakeMqtt) deliver(subscription, topic, payload string) {
+	f.lock.Lock()
+	handler := f.subs[subscription]
+	f.lock.Unlock()
+	handler(topic, []byte(payload))
+}
+
+func TestMqtt(t *testing.T) {
+	fc := &fakeClient{}
+	fm := &fakeMqtt{published: map[string]string{}, subs: map[string]func(string, []byte){}}
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"living room": {Address: "127.0.0.1", Id: "abc"}},
+		Mqtt:    &internal.MqttSpec{Broker: "tcp://127.0.0.1:1883", Interval: time.Hour},
+	}, map[string]internal.Client{"living room": fc})
+	e.dialMqtt = func(_ internal.MqttSpec, will mqttMessage, onConnect func(mqttConn), _ *slog.Logger) (mqttConn, error) {
+		assert.Equal(t, "tuya/status", will.topic)
+		onConnect(fm)
+		return fm, nil
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, ok := fm.message("tuya/living_room/state")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	state, _ := fm.message("tuya/living_room/state")
+	assert.JSONEq(t, `{"switch":"ON","current":0.05,"power":10,"voltage":230}`, state)
+	avail, _ := fm.message("tuya/living_room/availability")
+	assert.Equal(t, "online", avail)
+	status, _ := fm.message("tuya/status")
+	assert.Equal(t, "online", status)
+
+	var sw, power haConfig
+	payload, _ := fm.message("homeassistant/switch/tuya_abc/switch/config")
+	assert.NoError(t, json.Unmarshal([]byte(payload), &sw))
+	assert.Nil(t, sw.Name)
+	assert.Equal(t, "tuya/living_room/switch/set", sw.CommandTopic)
+	assert.Equal(t, "living room", sw.Device.Name)
+	payload, _ = fm.message("homeassistant/sensor/tuya_abc/power/config")
+	assert.NoError(t, json.Unmarshal([]byte(payload), &power))
+	assert.Equal(t, "W", power.UnitOfMeasurement)
+	assert.Equal(t, "{{ value_json.power }}", power.ValueTemplate)
+
+	fm.deliver("tuya/+/switch/set", "tuya/living_room/switch/set", "OFF")
+	fc.lock.Lock()
+	assert.Contains(t, fc.sent, proto.CmdIdTypeControl)
+	fc.lock.Unlock()
+	// unknown device and invalid payload are ignored
+	fm.deliver("tuya/+/switch/set", "tuya/kitchen/switch/set", "OFF")
+	fm.deliver("tuya/+/switch/set", "tuya/living_room/switch/set", "toggle")
+
+	// removed device disappears from Home Assistant
+	e.Reload(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{},
+		Mqtt:    &internal.MqttSpec{Broker: "tcp://127.0.0.1:1883", Interval: time.Hour},
+	})
+	assert.Eventually(t, func() bool {
+		payload, _ := fm.message("homeassistant/switch/tuya_abc/switch/config")
+		return payload == ""
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+	status, _ = fm.message("tuya/status")
+	assert.Equal(t, "offline", status)
+	assert.True(t, fm.closed)
+}
+
+func TestMqttReload(t *testing.T) {
+	fm := &fakeMqtt{published: map[string]string{}, subs: map[string]func(string, []byte){}}
+	cfg := &internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"plug": {Address: "127.0.0.1", Id: "abc"}},
+		Mqtt:    &internal.MqttSpec{Broker: "tcp://127.0.0.1:1883", Interval: time.Hour},
+	}
+	e := newTestExporter(cfg, map[string]internal.Client{"plug": &fakeClient{}})
+	var dials atomic.Int32
+	e.dialMqtt = func(_ internal.MqttSpec, _ mqttMessage, onConnect func(mqttConn), _ *slog.Logger) (mqttConn, error) {
+		dials.Add(1)
+		onConnect(fm)
+		return fm, nil
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		payload, _ := fm.message("homeassistant/switch/tuya_abc/switch/config")
+		return payload != ""
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// explicit interval doesn't depend on polling, so publisher keeps running
+	e.Reload(&internal.ConfigSpec{
+		Devices: cfg.Devices,
+		Mqtt:    cfg.Mqtt,
+		Polling: &internal.PollingSpec{Interval: time.Hour},
+	})
+	assert.Equal(t, int32(1), dials.Load())
+
+	// device removed along with change of broker settings is removed from Home Assistant by new publisher
+	e.Reload(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{},
+		Mqtt:    &internal.MqttSpec{Broker: "tcp://127.0.0.1:1883", Interval: time.Hour, ClientId: "other"},
+	})
+	assert.Equal(t, int32(2), dials.Load())
+	assert.Eventually(t, func() bool {
+		payload, _ := fm.message("homeassistant/switch/tuya_abc/switch/config")
+		return payload == ""
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+// TestMqttBroker runs against real broker, such as local Mosquitto, given by MQTT_BROKER environment variable.
+func TestMqttBroker(t *testing.T) {
+	broker := os.Getenv("MQTT_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_BROKER is not set")
+	}
+	connected := make(chan mqttConn, 1)
+	spec := internal.MqttSpec{Broker: broker, ClientId: "tuya-smartplug-exporter-test"}
+	conn, err := dialPaho(spec, mqttMessage{topic: "tuya-test/status", payload: []byte("offline")},
+		func(c mqttConn) { connected <- c }, slog.New(slog.DiscardHandler))
+	assert.NoError(t, err)
+	defer conn.Close()
+	select {
+	case <-connected:
+	case <-time.After(mqttTimeout):
+		t.Fatal("not connected to broker")
+	}
+	received := make(chan string, 1)
+	assert.NoError(t, conn.Subscribe("tuya-test/+/switch/set", func(_ string, payload []byte) {
+		received <- string(payload)
+	}))
+	assert.NoError(t, conn.Publish("tuya-test/dev1/switch/set", []byte("ON"), false))
+	select {
+	case payload := <-received:
+		assert.Equal(t, "ON", payload)
+	case <-time.After(mqttTimeout):
+		t.Fatal("message not received")
+	}
+}
@@ -0,0 +1,352 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/version"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const (
+	sinkOtlp = "otlp"
+
+	otlpScope          = "github.com/rkosegi/tuya-smartplug-exporter"
+	otlpServiceName    = "tuya-smartplug-exporter"
+	otlpHttpPath       = "/v1/metrics"
+	defaultOtlpTimeout = 10 * time.Second
+)
+
+// otlpDevice holds instruments of single device, exported as separate resource.
+type otlpDevice struct {
+	dc       internal.DeviceConnectionSpec
+	provider *sdkmetric.MeterProvider
+	reader   *sdkmetric.ManualReader
+}
+
+// otlpSink periodically exports readings of all devices to OpenTelemetry collector.
+type otlpSink struct {
+	e      *exporter
+	spec   internal.OtlpSpec
+	exp    sdkmetric.Exporter
+	l      *slog.Logger
+	cancel context.CancelFunc
+	// closed once run returns
+	done chan struct{}
+	// only accessed from run
+	devices map[string]*otlpDevice
+}
+
+func (e *exporter) otlpEnabled() bool {
+	return e.cfg.Otlp != nil
+}
+
+// otlpSpec returns OTLP settings with defaults applied.
+func (e *exporter) otlpSpec() internal.OtlpSpec {
+	spec := *e.cfg.Otlp
+	spec.Protocol = lo.CoalesceOrEmpty(spec.Protocol, internal.OtlpSpecProtocolGrpc)
+	spec.Interval = e.pushInterval(spec.Interval)
+	spec.Timeout = lo.CoalesceOrEmpty(spec.Timeout, defaultOtlpTimeout)
+	return spec
+}
+
+// newOtlpExporter creates exporter for given protocol. Connection is established lazily.
+func newOtlpExporter(ctx context.Context, spec internal.OtlpSpec) (sdkmetric.Exporter, error) {
+	u, err := url.Parse(spec.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint '%s'", spec.Endpoint)
+	}
+	headers := lo.FromPtr(spec.Headers)
+	if spec.Protocol == internal.OtlpSpecProtocolHttp {
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpointURL(spec.Endpoint),
+			otlpmetrichttp.WithHeaders(headers),
+			otlpmetrichttp.WithTimeout(spec.Timeout),
+		}
+		if strings.Trim(u.Path, "/") == "" {
+			opts = append(opts, otlpmetrichttp.WithURLPath(otlpHttpPath))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+	return otlpmetricgrpc.New(ctx,
+		otlpmetricgrpc.WithEndpointURL(spec.Endpoint),
+		otlpmetricgrpc.WithHeaders(headers),
+		otlpmetricgrpc.WithTimeout(spec.Timeout),
+	)
+}
+
+// startOtlp starts exporting to OpenTelemetry collector, if it's enabled and sink is not running yet.
+// Caller must hold write lock.
+func (e *exporter) startOtlp() {
+	if e.runCtx == nil || !e.otlpEnabled() || e.otlp != nil {
+		return
+	}
+	spec := e.otlpSpec()
+	exp, err := newOtlpExporter(e.runCtx, spec)
+	if err != nil {
+		e.l.Error("unable to create OTLP exporter", "endpoint", spec.Endpoint, "error", err)
+		return
+	}
+	ctx, cancel := context.WithCancel(e.runCtx)
+	e.otlp = &otlpSink{
+		e:       e,
+		spec:    spec,
+		exp:     exp,
+		l:       e.l.With("sink", sinkOtlp),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		devices: map[string]*otlpDevice{},
+	}
+	e.l.Info("exporting readings using OTLP", "endpoint", spec.Endpoint, "protocol", spec.Protocol, "interval", spec.Interval)
+	go e.otlp.run(ctx)
+}
+
+// stopOtlp stops exporting to OpenTelemetry collector. Last readings are exported once more.
+// Returned function waits for that export, it should be called after releasing write lock.
+// Caller must hold write lock.
+func (e *exporter) stopOtlp() func() {
+	s := e.otlp
+	if s == nil {
+		return func() {}
+	}
+	s.cancel()
+	e.otlp = nil
+	return func() {
+		<-s.done
+	}
+}
+
+func (s *otlpSink) run(ctx context.Context) {
+	defer close(s.done)
+	t := time.NewTicker(s.spec.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.shutdown()
+			return
+		case <-t.C:
+			s.exportAll(ctx)
+		}
+	}
+}
+
+// shutdown exports readings collected so far, without querying devices, and releases exporter.
+func (s *otlpSink) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.spec.Timeout)
+	defer cancel()
+	s.e.cfgLock.RLock()
+	batch, err := s.read(ctx)
+	s.e.cfgLock.RUnlock()
+	if err != nil {
+		s.l.Warn("unable to collect readings", "error", err)
+	}
+	s.export(ctx, batch)
+	for _, dev := range s.devices {
+		_ = dev.provider.Shutdown(ctx)
+	}
+	if err := s.exp.Shutdown(ctx); err != nil {
+		s.l.Warn("unable to shutdown OTLP exporter", "error", err)
+	}
+}
+
+// exportAll collects current readings of all devices and exports them, one resource per device.
+func (s *otlpSink) exportAll(ctx context.Context) {
+	batch, err := s.collect(ctx)
+	if err != nil {
+		s.l.Warn("unable to collect readings", "error", err)
+	}
+	s.export(ctx, batch)
+}
+
+func (s *otlpSink) export(ctx context.Context, batch []*metricdata.ResourceMetrics) {
+	for _, rm := range batch {
+		if err := s.exp.Export(ctx, rm); err != nil {
+			s.e.m.SinkFailures.WithLabelValues(sinkOtlp).Inc()
+			s.l.Warn("export failed", "error", err)
+			continue
+		}
+		points := 0
+		for _, sm := range rm.ScopeMetrics {
+			points += len(sm.Metrics)
+		}
+		s.e.m.SinkWritten.WithLabelValues(sinkOtlp).Add(float64(points))
+	}
+}
+
+func (s *otlpSink) collect(ctx context.Context) ([]*metricdata.ResourceMetrics, error) {
+	e := s.e
+	e.cfgLock.RLock()
+	names := slices.Collect(maps.Keys(e.cfg.Devices))
+	polling := e.polling()
+	e.cfgLock.RUnlock()
+	if !polling {
+		var wg sync.WaitGroup
+		for _, dname := range names {
+			wg.Go(func() {
+				e.fetch(dname)
+			})
+		}
+		wg.Wait()
+	}
+	e.cfgLock.RLock()
+	defer e.cfgLock.RUnlock()
+	// sink might have been stopped while querying devices
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+	syncErr := s.syncDevices()
+	batch, err := s.read(ctx)
+	return batch, errors.Join(syncErr, err)
+}
+
+// read collects metrics of known devices, one resource per device.
+// Caller must hold read lock.
+func (s *otlpSink) read(ctx context.Context) ([]*metricdata.ResourceMetrics, error) {
+	var (
+		errs  []error
+		batch []*metricdata.ResourceMetrics
+	)
+	for _, dname := range slices.Sorted(maps.Keys(s.devices)) {
+		rm := &metricdata.ResourceMetrics{}
+		if err := s.devices[dname].reader.Collect(ctx, rm); err != nil {
+			errs = append(errs, fmt.Errorf("device '%s': %w", dname, err))
+			continue
+		}
+		if len(rm.ScopeMetrics) > 0 {
+			batch = append(batch, rm)
+		}
+	}
+	return batch, errors.Join(errs...)
+}
+
+// syncDevices creates instruments of devices added or changed by reload and drops ones of removed devices.
+// Caller must hold read lock.
+func (s *otlpSink) syncDevices() error {
+	e := s.e
+	for dname, dev := range s.devices {
+		if dc, ok := e.cfg.Devices[dname]; !ok || !reflect.DeepEqual(dc, dev.dc) {
+			_ = dev.provider.Shutdown(context.Background())
+			delete(s.devices, dname)
+		}
+	}
+	var errs []error
+	for dname, dc := range e.cfg.Devices {
+		if _, ok := s.devices[dname]; ok {
+			continue
+		}
+		dev, err := s.newDevice(dname, dc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("device '%s': %w", dname, err))
+			continue
+		}
+		s.devices[dname] = dev
+	}
+	return errors.Join(errs...)
+}
+
+// resource describes device using its settings. Key is never included.
+// Caller must hold read lock.
+func (s *otlpSink) resource(dname string, dc internal.DeviceConnectionSpec) *resource.Resource {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", otlpServiceName),
+		attribute.String("service.version", version.Version),
+		attribute.String("tuya.device.name", dname),
+	}
+	for k, v := range map[string]string{
+		"tuya.device.id":       dc.Id,
+		"tuya.device.address":  dc.Address,
+		"tuya.device.protocol": dc.Protocol,
+		"tuya.device.profile":  dc.Profile,
+	} {
+		if v != "" {
+			attrs = append(attrs, attribute.String(k, v))
+		}
+	}
+	for _, ln := range s.e.cfg.ExtraLabelNames() {
+		if v := lo.FromPtr(dc.ExtraLabels)[ln]; v != "" {
+			attrs = append(attrs, attribute.String(s.e.cfg.ExportedLabelName(ln), v))
+		}
+	}
+	return resource.NewSchemaless(attrs...)
+}
+
+// newDevice creates meter provider of device along with its instruments.
+// Caller must hold read lock.
+func (s *otlpSink) newDevice(dname string, dc internal.DeviceConnectionSpec) (*otlpDevice, error) {
+	e := s.e
+	reader := sdkmetric.NewManualReader(
+		sdkmetric.WithTemporalitySelector(s.exp.Temporality),
+		sdkmetric.WithAggregationSelector(s.exp.Aggregation),
+	)
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(s.resource(dname, dc)))
+	meter := provider.Meter(otlpScope, metric.WithInstrumentationVersion(version.Version))
+	voltage, err1 := meter.Float64ObservableGauge("tuya.smartplug.voltage",
+		metric.WithUnit("V"), metric.WithDescription("Electrical voltage"))
+	current, err2 := meter.Float64ObservableGauge("tuya.smartplug.current",
+		metric.WithUnit("A"), metric.WithDescription("Electrical current drawn"))
+	power, err3 := meter.Float64ObservableGauge("tuya.smartplug.power",
+		metric.WithUnit("W"), metric.WithDescription("Power used"))
+	switchOn, err4 := meter.Int64ObservableGauge("tuya.smartplug.switch",
+		metric.WithUnit("1"), metric.WithDescription("Whether the plug is switched on (1 for on, 0 for off)"))
+	energy, err5 := meter.Float64ObservableCounter("tuya.smartplug.energy",
+		metric.WithUnit("kWh"), metric.WithDescription("Energy used since device was first queried, estimated from power readings"))
+	if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+		return nil, err
+	}
+	// callback runs within collect, while read lock is held
+	_, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		ds, ok := e.snapshot(dname)
+		if !ok {
+			return nil
+		}
+		o.ObserveFloat64(energy, ds.energy)
+		good := e.goodReading(dname, ds)
+		if good == nil {
+			return nil
+		}
+		pr := good.plug
+		o.ObserveFloat64(voltage, pr.Voltage)
+		o.ObserveFloat64(current, pr.Current)
+		o.ObserveFloat64(power, pr.Power)
+		o.ObserveInt64(switchOn, lo.Ternary[int64](pr.SwitchOn, 1, 0))
+		return nil
+	}, voltage, current, power, switchOn, energy)
+	if err != nil {
+		return nil, err
+	}
+	return &otlpDevice{dc: dc, provider: provider, reader: reader}, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/stretchr/testify/assert"
+	colmetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEstimateEnergy(t *testing.T) {
+	at := time.Now()
+	prev := &reading{plug: internal.PlugReading{Power: 100}, at: at}
+	cur := &reading{plug: internal.PlugReading{Power: 300}, at: at.Add(time.Hour)}
+	// 200 W on average for one hour
+	assert.InDelta(t, 0.2, estimateEnergy(prev, cur), 1e-9)
+}
+
+func TestOtlp(t *testing.T) {
+	requests := make(chan *colmetrics.ExportMetricsServiceRequest, 10)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		data, _ := io.ReadAll(r.Body)
+		req := &colmetrics.ExportMetricsServiceRequest{}
+		assert.NoError(t, proto.Unmarshal(data, req))
+		select {
+		case requests <- req:
+		default:
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer stub.Close()
+
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {
+			Address:     "127.0.0.1",
+			Id:          "abc",
+			Key:         "0123456789abcdef",
+			Protocol:    "tuya3.1",
+			ExtraLabels: &map[string]string{"room": "kitchen"},
+		}},
+		ExtraDeviceLabels: &internal.ExtraDeviceLabels{"room"},
+		Otlp: &internal.OtlpSpec{
+			Endpoint: stub.URL,
+			Protocol: internal.OtlpSpecProtocolHttp,
+			Headers:  &map[string]string{"Authorization": "Bearer secret"},
+			Interval: 20 * time.Millisecond,
+		},
+	}, map[string]internal.Client{"dev1": &fakeClient{}})
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	var req *colmetrics.ExportMetricsServiceRequest
+	select {
+	case req = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing exported")
+	}
+	cancel()
+	<-done
+
+	assert.Len(t, req.ResourceMetrics, 1)
+	rm := req.ResourceMetrics[0]
+	attrs := map[string]string{}
+	for _, kv := range rm.Resource.Attributes {
+		attrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	assert.Equal(t, "dev1", attrs["tuya.device.name"])
+	assert.Equal(t, "abc", attrs["tuya.device.id"])
+	assert.Equal(t, "tuya3.1", attrs["tuya.device.protocol"])
+	assert.Equal(t, "kitchen", attrs["room"])
+	assert.NotContains(t, attrs, "tuya.device.key")
+
+	units := map[string]string{}
+	values := map[string]float64{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		units[m.Name] = m.Unit
+		if g := m.GetGauge(); g != nil {
+			values[m.Name] = g.DataPoints[0].GetAsDouble()
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"tuya.smartplug.voltage": "V",
+		"tuya.smartplug.current": "A",
+		"tuya.smartplug.power":   "W",
+		"tuya.smartplug.switch":  "1",
+		"tuya.smartplug.energy":  "kWh",
+	}, units)
+	assert.Equal(t, 230.0, values["tuya.smartplug.voltage"])
+	assert.Equal(t, 10.0, values["tuya.smartplug.power"])
+}
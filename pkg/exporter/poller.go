@@ -0,0 +1,133 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"time"
+)
+
+const defaultPollInterval = 30 * time.Second
+
+func (e *exporter) polling() bool {
+	return e.cfg.Polling != nil
+}
+
+func (e *exporter) pollInterval(dname string) time.Duration {
+	if d := e.cfg.Devices[dname].PollInterval; d > 0 {
+		return d
+	}
+	if e.cfg.Polling != nil && e.cfg.Polling.Interval > 0 {
+		return e.cfg.Polling.Interval
+	}
+	return defaultPollInterval
+}
+
+// pushInterval returns interval of periodic push, which defaults to polling interval.
+func (e *exporter) pushInterval(d time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	if e.cfg.Polling != nil && e.cfg.Polling.Interval > 0 {
+		return e.cfg.Polling.Interval
+	}
+	return defaultPollInterval
+}
+
+func (e *exporter) staleAfter(dname string) time.Duration {
+	if e.cfg.Polling != nil && e.cfg.Polling.StaleAfter > 0 {
+		return e.cfg.Polling.StaleAfter
+	}
+	return 3 * e.pollInterval(dname)
+}
+
+func (e *exporter) Run(ctx context.Context) {
+	e.reloadLock.Lock()
+	e.cfgLock.Lock()
+	e.runCtx = ctx
+	e.startDiscovery()
+	e.startPollers()
+	e.startMqtt()
+	e.startInflux()
+	e.startOtlp()
+	e.startRemoteWrite()
+	e.cfgLock.Unlock()
+	e.reloadLock.Unlock()
+	<-ctx.Done()
+	e.reloadLock.Lock()
+	defer e.reloadLock.Unlock()
+	e.cfgLock.Lock()
+	e.stopDiscovery()
+	teardown := []func(){e.stopMqtt(), e.stopInflux(), e.stopOtlp(), e.stopRemoteWrite()}
+	for dname := range e.pollers {
+		e.stopPoller(dname)
+	}
+	e.cfgLock.Unlock()
+	// sinks flush remaining data, so Run returns only after they are done
+	for _, fn := range teardown {
+		fn()
+	}
+}
+
+// startPollers starts poller for every device that doesn't have one yet.
+// Caller must hold write lock.
+func (e *exporter) startPollers() {
+	if e.runCtx == nil || !e.polling() {
+		return
+	}
+	for dname := range e.cfg.Devices {
+		if _, ok := e.pollers[dname]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(e.runCtx)
+		e.pollers[dname] = cancel
+		go e.pollDevice(ctx, dname)
+	}
+}
+
+// stopPoller stops poller of given device, if any.
+// Caller must hold write lock.
+func (e *exporter) stopPoller(dname string) {
+	if cancel, ok := e.pollers[dname]; ok {
+		cancel()
+		delete(e.pollers, dname)
+	}
+}
+
+func (e *exporter) pollDevice(ctx context.Context, dname string) {
+	e.cfgLock.RLock()
+	interval := e.pollInterval(dname)
+	delay := e.startDelay(dname)
+	e.cfgLock.RUnlock()
+	e.l.Debug("starting poller", "device", dname, "interval", interval, "delay", delay)
+	// pollers are offset once, so that devices keep being queried at different times
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(delay):
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		e.fetch(dname)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+)
+
+// DefaultCredentials is name of credentials entry used by probe when none is given.
+const DefaultCredentials = "default"
+
+// collectorFunc is unchecked collector, which describes nothing upfront.
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(chan<- *prometheus.Desc) {}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) {
+	f(ch)
+}
+
+// Probe queries single device, given either by name or by its address.
+// Configuration lock isn't held while device is queried.
+func (e *exporter) Probe(params url.Values) (prometheus.Gatherer, error) {
+	start := time.Now()
+	var (
+		dname string
+		dc    internal.DeviceConnectionSpec
+		ds    deviceState
+	)
+	switch {
+	case params.Get("device") != "":
+		dname = params.Get("device")
+		e.cfgLock.RLock()
+		var ok bool
+		dc, ok = e.cfg.Devices[dname]
+		e.cfgLock.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown device '%s'", dname)
+		}
+		r := e.fetch(dname)
+		// state is missing when breaker is open and device was never queried, reading is nil then too
+		ds, _ = e.snapshot(dname)
+		ds.last = r
+
+	case params.Get("target") != "":
+		dname = params.Get("target")
+		q, err := e.adHocQuery(dname, params)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = q.cl.Close()
+		}()
+		dc = q.dc
+		r := e.queryDevice(dname, q)
+		ds = deviceState{last: r, errors: map[string]int{}, queries: 1, queryTime: r.took}
+		if r.err == nil {
+			ds.lastGood = r
+		} else {
+			ds.errors[r.reason]++
+		}
+
+	default:
+		return nil, errors.New("either device or target parameter is required")
+	}
+
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Whether the probe succeeded (1 for success, 0 for failure).",
+	})
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "How long the probe took to complete, in seconds.",
+	})
+	if ds.last != nil && ds.last.err == nil {
+		success.Set(1)
+	}
+	duration.Set(time.Since(start).Seconds())
+	e.cfgLock.RLock()
+	defer e.cfgLock.RUnlock()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(success, duration)
+	if ds.last != nil {
+		reg.MustRegister(collectorFunc(func(ch chan<- prometheus.Metric) {
+			e.emitDevice(dname, dc, ds, ch)
+		}))
+	}
+	// gather right away, configuration can't change while it's in use
+	mfs, err := reg.Gather()
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return mfs, err
+	}), nil
+}
+
+// adHocQuery builds query of device that is not part of configuration.
+func (e *exporter) adHocQuery(dname string, params url.Values) (*deviceQuery, error) {
+	e.cfgLock.RLock()
+	defer e.cfgLock.RUnlock()
+	dc, err := e.probeSpec(params)
+	if err != nil {
+		return nil, err
+	}
+	return e.newQuery(e.newClient("", dc), dc), nil
+}
+
+// probeSpec builds connection specification of device that is not part of configuration.
+// Caller must hold read lock.
+func (e *exporter) probeSpec(params url.Values) (internal.DeviceConnectionSpec, error) {
+	dc := internal.DeviceConnectionSpec{
+		Address: params.Get("target"),
+		Id:      params.Get("id"),
+		Profile: params.Get("profile"),
+	}
+	if dc.Id == "" {
+		return dc, errors.New("id parameter is required along with target")
+	}
+	name := lo.CoalesceOrEmpty(params.Get("credentials"), DefaultCredentials)
+	cs, ok := lo.FromPtr(e.cfg.Credentials)[name]
+	if !ok {
+		return dc, fmt.Errorf("unknown credentials '%s'", name)
+	}
+	dc.Key = cs.Key
+	dc.Protocol = cs.Protocol
+	// take remaining settings from defaults
+	tmp := internal.ConfigSpec{Defaults: e.cfg.Defaults, Devices: internal.DevicesContainer{dc.Address: dc}}
+	tmp.ApplyDefaults()
+	dc = tmp.Devices[dc.Address]
+	// extra labels don't apply to device that is not configured
+	dc.ExtraLabels = nil
+	if _, ok = internal.LookupProfile(dc.Profile); !ok {
+		return dc, fmt.Errorf("unknown profile '%s', must be one of %v", dc.Profile, internal.ProfileNames())
+	}
+	return dc, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"reflect"
+
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+)
+
+func (e *exporter) Reload(cfg *internal.ConfigSpec) {
+	e.reloadLock.Lock()
+	defer e.reloadLock.Unlock()
+	e.cfgLock.Lock()
+	teardown := e.apply(cfg)
+	e.cfgLock.Unlock()
+	// stopped sinks might talk to remote service for a while, readers aren't blocked meanwhile
+	teardown()
+	e.cfgLock.Lock()
+	defer e.cfgLock.Unlock()
+	e.startDiscovery()
+	e.startPollers()
+	e.startMqtt()
+	e.startInflux()
+	e.startOtlp()
+	e.startRemoteWrite()
+	if e.mqtt != nil {
+		// announce added devices and remove deleted ones
+		e.mqtt.nudge()
+	}
+}
+
+// apply replaces configuration and stops everything affected by the change.
+// Returned function finishes stopping of sinks, it must be called after releasing write lock, before sinks are started again.
+// Caller must hold write lock.
+func (e *exporter) apply(cfg *internal.ConfigSpec) func() {
+	old := e.cfg
+	e.cfg = cfg
+	pollingChanged := !reflect.DeepEqual(old.Polling, cfg.Polling)
+	breakerChanged := !reflect.DeepEqual(old.CircuitBreaker, cfg.CircuitBreaker)
+	// clients use resolver only when discovery is enabled
+	discoveryChanged := !reflect.DeepEqual(old.Discovery, cfg.Discovery)
+	if discoveryChanged {
+		e.stopDiscovery()
+	}
+	var teardown []func()
+	// interval of periodic push defaults to polling interval, so settings with defaults applied are compared
+	if e.mqtt != nil && (!e.mqttEnabled() || !reflect.DeepEqual(e.mqtt.spec, e.mqttSpec())) {
+		teardown = append(teardown, e.stopMqtt())
+	}
+	if e.otlp != nil && (!e.otlpEnabled() || !reflect.DeepEqual(e.otlp.spec, e.otlpSpec())) {
+		teardown = append(teardown, e.stopOtlp())
+	}
+	if e.remoteWrite != nil && (!e.remoteWriteEnabled() || !reflect.DeepEqual(e.remoteWrite.spec, e.remoteWriteSpec())) {
+		teardown = append(teardown, e.stopRemoteWrite())
+	}
+	if !reflect.DeepEqual(old.Influxdb, cfg.Influxdb) {
+		teardown = append(teardown, e.stopInflux())
+	}
+
+	for dname, dc := range old.Devices {
+		if ndc, ok := cfg.Devices[dname]; ok && reflect.DeepEqual(dc, ndc) && !discoveryChanged {
+			if pollingChanged {
+				e.stopPoller(dname)
+			}
+			continue
+		}
+		if _, ok := cfg.Devices[dname]; ok {
+			e.l.Info("device changed", "device", dname)
+		} else {
+			e.l.Info("device removed", "device", dname)
+		}
+		e.stopPoller(dname)
+		// client closes its connection after every query, closing it here would interfere with query in progress
+		delete(e.clients, dname)
+		e.lock.Lock()
+		delete(e.state, dname)
+		e.lock.Unlock()
+	}
+	for dname, dc := range cfg.Devices {
+		if _, ok := e.clients[dname]; !ok {
+			if _, existed := old.Devices[dname]; !existed {
+				e.l.Info("device added", "device", dname)
+			}
+			e.clients[dname] = e.newClient(dname, dc)
+		}
+	}
+	if breakerChanged {
+		e.lock.Lock()
+		for _, ds := range e.state {
+			ds.breaker = nil
+			if cfg.CircuitBreaker != nil {
+				ds.breaker = newBreaker(cfg.CircuitBreaker)
+			}
+		}
+		e.lock.Unlock()
+	}
+	e.setupLimits()
+	return func() {
+		for _, fn := range teardown {
+			fn()
+		}
+	}
+}
@@ -0,0 +1,426 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/config"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/samber/lo"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	sinkRemoteWrite = "remote_write"
+
+	defaultRemoteWriteTimeout   = 10 * time.Second
+	defaultRemoteWriteQueueSize = 1000
+	remoteWriteQueueDirName     = "tuya-smartplug-exporter-remote-write"
+	// queued batch is stored as <timestamp>-<number of samples>.rw
+	remoteWriteBatchExt    = ".rw"
+	remoteWriteVersion     = "0.1.0"
+	maxRemoteWriteErrorLen = 512
+)
+
+type rwLabel struct {
+	name  string
+	value string
+}
+
+// rwSeries is time series with single sample, as sent by remote write.
+type rwSeries struct {
+	labels []rwLabel
+	value  float64
+	ts     int64
+}
+
+// remoteWriteSink periodically gathers metrics of exporter and sends them to remote write endpoint.
+// Every batch is stored in on-disk queue first and removed once it was sent, so batches survive outage of endpoint.
+type remoteWriteSink struct {
+	spec     internal.RemoteWriteSpec
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	m        GlobalMetrics
+	l        *slog.Logger
+	cancel   context.CancelFunc
+	// closed once run returns
+	done chan struct{}
+}
+
+func (e *exporter) remoteWriteEnabled() bool {
+	return e.cfg.RemoteWrite != nil
+}
+
+// remoteWriteSpec returns remote write settings with defaults applied.
+func (e *exporter) remoteWriteSpec() internal.RemoteWriteSpec {
+	spec := *e.cfg.RemoteWrite
+	spec.Interval = e.pushInterval(spec.Interval)
+	spec.Timeout = lo.CoalesceOrEmpty(spec.Timeout, defaultRemoteWriteTimeout)
+	spec.QueueDir = lo.CoalesceOrEmpty(spec.QueueDir, filepath.Join(os.TempDir(), remoteWriteQueueDirName))
+	spec.QueueSize = lo.CoalesceOrEmpty(spec.QueueSize, defaultRemoteWriteQueueSize)
+	return spec
+}
+
+// newRemoteWriteClient creates HTTP client with configured authentication and TLS settings.
+func newRemoteWriteClient(spec internal.RemoteWriteSpec) (*http.Client, error) {
+	cfg := config.DefaultHTTPClientConfig
+	if ba := spec.BasicAuth; ba != nil {
+		cfg.BasicAuth = &config.BasicAuth{
+			Username:     ba.Username,
+			Password:     config.Secret(ba.Password),
+			PasswordFile: ba.PasswordFile,
+		}
+	}
+	if spec.BearerToken != "" || spec.BearerTokenFile != "" {
+		cfg.Authorization = &config.Authorization{
+			Type:            "Bearer",
+			Credentials:     config.Secret(spec.BearerToken),
+			CredentialsFile: spec.BearerTokenFile,
+		}
+	}
+	if t := spec.Tls; t != nil {
+		cfg.TLSConfig = config.TLSConfig{
+			CAFile:             t.CaFile,
+			CertFile:           t.CertFile,
+			KeyFile:            t.KeyFile,
+			ServerName:         t.ServerName,
+			InsecureSkipVerify: t.InsecureSkipVerify,
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	client, err := config.NewClientFromConfig(cfg, sinkRemoteWrite)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = spec.Timeout
+	return client, nil
+}
+
+// startRemoteWrite starts sending samples to remote write endpoint, if it's enabled and sink is not running yet.
+// Caller must hold write lock.
+func (e *exporter) startRemoteWrite() {
+	if e.runCtx == nil || !e.remoteWriteEnabled() || e.remoteWrite != nil {
+		return
+	}
+	spec := e.remoteWriteSpec()
+	client, err := newRemoteWriteClient(spec)
+	if err != nil {
+		e.l.Error("unable to create remote write client", "url", spec.Url, "error", err)
+		return
+	}
+	if err = os.MkdirAll(spec.QueueDir, 0o700); err != nil {
+		e.l.Error("unable to create remote write queue", "dir", spec.QueueDir, "error", err)
+		return
+	}
+	reg := prometheus.NewRegistry()
+	if err = reg.Register(e); err != nil {
+		e.l.Error("unable to register exporter for remote write", "error", err)
+		return
+	}
+	ctx, cancel := context.WithCancel(e.runCtx)
+	e.remoteWrite = &remoteWriteSink{
+		spec:     spec,
+		client:   client,
+		gatherer: reg,
+		m:        e.m,
+		l:        e.l.With("sink", sinkRemoteWrite),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	e.l.Info("sending samples using remote write", "url", spec.Url, "interval", spec.Interval, "queue", spec.QueueDir)
+	go e.remoteWrite.run(ctx)
+}
+
+// stopRemoteWrite stops sending samples. Queued batches are kept on disk.
+// Returned function waits for batch being sent, it should be called after releasing write lock.
+// Caller must hold write lock.
+func (e *exporter) stopRemoteWrite() func() {
+	s := e.remoteWrite
+	if s == nil {
+		return func() {}
+	}
+	s.cancel()
+	e.remoteWrite = nil
+	return func() {
+		<-s.done
+	}
+}
+
+func (s *remoteWriteSink) run(ctx context.Context) {
+	defer close(s.done)
+	// batches queued before restart are sent first
+	s.updateQueueLength()
+	t := time.NewTicker(s.spec.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := s.enqueue(s.collect()); err != nil {
+			s.l.Error("unable to queue samples", "error", err)
+		}
+		s.drain(ctx)
+	}
+}
+
+// collect gathers metrics of exporter and converts them to time series, all timestamped by collection time.
+func (s *remoteWriteSink) collect() []rwSeries {
+	now := time.Now()
+	mfs, err := s.gatherer.Gather()
+	if err != nil {
+		// partial result is still sent
+		s.l.Warn("error while gathering metrics", "error", err)
+	}
+	return toTimeSeries(mfs, now, lo.FromPtr(s.spec.ExternalLabels))
+}
+
+// enqueue stores series as new batch in on-disk queue, dropping the oldest batches when queue is full.
+func (s *remoteWriteSink) enqueue(series []rwSeries) error {
+	if len(series) == 0 {
+		return nil
+	}
+	data := snappy.Encode(nil, encodeWriteRequest(series))
+	name := fmt.Sprintf("%020d-%d%s", time.Now().UnixNano(), len(series), remoteWriteBatchExt)
+	tmp := filepath.Join(s.spec.QueueDir, "."+name)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.spec.QueueDir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	batches := s.queued()
+	for _, name := range batches[:max(0, len(batches)-s.spec.QueueSize)] {
+		s.l.Warn("queue is full, dropping oldest batch", "batch", name)
+		s.remove(name)
+		s.m.SinkDropped.WithLabelValues(sinkRemoteWrite).Add(float64(batchSamples(name)))
+	}
+	s.updateQueueLength()
+	return nil
+}
+
+// drain sends queued batches, oldest first. It stops at first batch that can be retried later,
+// since samples of the same series must be sent in order.
+func (s *remoteWriteSink) drain(ctx context.Context) {
+	defer s.updateQueueLength()
+	for _, name := range s.queued() {
+		if ctx.Err() != nil {
+			return
+		}
+		data, err := os.ReadFile(filepath.Join(s.spec.QueueDir, name))
+		if err == nil {
+			err = s.send(ctx, data)
+		}
+		samples := float64(batchSamples(name))
+		if err == nil {
+			s.remove(name)
+			s.m.SinkWritten.WithLabelValues(sinkRemoteWrite).Add(samples)
+			continue
+		}
+		s.m.SinkFailures.WithLabelValues(sinkRemoteWrite).Inc()
+		var perr *permanentError
+		if errors.As(err, &perr) {
+			s.l.Error("batch rejected, dropping it", "batch", name, "error", err)
+			s.remove(name)
+			s.m.SinkDropped.WithLabelValues(sinkRemoteWrite).Add(samples)
+			continue
+		}
+		s.l.Warn("unable to send batch, will retry on next interval", "batch", name, "error", err)
+		return
+	}
+}
+
+// queued returns names of queued batches, oldest first.
+func (s *remoteWriteSink) queued() []string {
+	entries, err := os.ReadDir(s.spec.QueueDir)
+	if err != nil {
+		s.l.Warn("unable to read queue", "dir", s.spec.QueueDir, "error", err)
+		return nil
+	}
+	var names []string
+	for _, de := range entries {
+		if de.Type().IsRegular() && !strings.HasPrefix(de.Name(), ".") && strings.HasSuffix(de.Name(), remoteWriteBatchExt) {
+			names = append(names, de.Name())
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (s *remoteWriteSink) remove(name string) {
+	if err := os.Remove(filepath.Join(s.spec.QueueDir, name)); err != nil {
+		s.l.Warn("unable to remove batch from queue", "batch", name, "error", err)
+	}
+}
+
+func (s *remoteWriteSink) updateQueueLength() {
+	total := 0
+	for _, name := range s.queued() {
+		total += batchSamples(name)
+	}
+	s.m.SinkQueueLength.WithLabelValues(sinkRemoteWrite).Set(float64(total))
+}
+
+// batchSamples returns number of samples in batch, as encoded in its name.
+func batchSamples(name string) int {
+	_, n, _ := strings.Cut(strings.TrimSuffix(name, remoteWriteBatchExt), "-")
+	cnt, _ := strconv.Atoi(n)
+	return cnt
+}
+
+// send posts single batch to remote write endpoint.
+func (s *remoteWriteSink) send(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.spec.Url, bytes.NewReader(data))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxRemoteWriteErrorLen))
+	err = fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+	// client errors other than rate limiting are not retried
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// toTimeSeries converts metric families into series with single sample each.
+// Summaries and histograms are split into series as in text exposition format.
+func toTimeSeries(mfs []*dto.MetricFamily, now time.Time, external map[string]string) []rwSeries {
+	var out []rwSeries
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(name string, value float64, extra ...string) {
+				labels := []rwLabel{{name: "__name__", value: name}}
+				seen := map[string]bool{}
+				for _, lp := range m.GetLabel() {
+					labels = append(labels, rwLabel{name: lp.GetName(), value: lp.GetValue()})
+					seen[lp.GetName()] = true
+				}
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels = append(labels, rwLabel{name: extra[i], value: extra[i+1]})
+					seen[extra[i]] = true
+				}
+				for k, v := range external {
+					if !seen[k] {
+						labels = append(labels, rwLabel{name: k, value: v})
+					}
+				}
+				slices.SortFunc(labels, func(a, b rwLabel) int {
+					return strings.Compare(a.name, b.name)
+				})
+				out = append(out, rwSeries{labels: labels, value: value, ts: ts})
+			}
+			name := mf.GetName()
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					add(name, q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add(name+"_sum", m.GetSummary().GetSampleSum())
+				add(name+"_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					infSeen = infSeen || math.IsInf(b.GetUpperBound(), 1)
+					add(name+"_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				if !infSeen {
+					add(name+"_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return out
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes series as remote write 1.0 WriteRequest protobuf message.
+func encodeWriteRequest(series []rwSeries) []byte {
+	var buf []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.ts))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
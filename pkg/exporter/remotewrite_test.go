@@ -0,0 +1,224 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest is counterpart of encodeWriteRequest.
+func decodeWriteRequest(t *testing.T, data []byte) []rwSeries {
+	var out []rwSeries
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			assert.GreaterOrEqual(t, n, 0)
+			b = b[n:]
+			n = fn(num, typ, b)
+			assert.GreaterOrEqual(t, n, 0)
+			b = b[n:]
+		}
+	}
+	fields(data, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		var s rwSeries
+		fields(ts, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			fields(msg, func(inner protowire.Number, typ protowire.Type, b []byte) int {
+				switch {
+				case num == 1:
+					v, n := protowire.ConsumeString(b)
+					if inner == 1 {
+						s.labels = append(s.labels, rwLabel{name: v})
+					} else {
+						s.labels[len(s.labels)-1].value = v
+					}
+					return n
+				case inner == 1:
+					v, n := protowire.ConsumeFixed64(b)
+					s.value = math.Float64frombits(v)
+					return n
+				default:
+					v, n := protowire.ConsumeVarint(b)
+					s.ts = int64(v)
+					return n
+				}
+			})
+			return n
+		})
+		out = append(out, s)
+		return n
+	})
+	return out
+}
+
+func seriesByName(series []rwSeries) map[string][]rwSeries {
+	res := map[string][]rwSeries{}
+	for _, s := range series {
+		res[s.labels[0].value] = append(res[s.labels[0].value], s)
+	}
+	return res
+}
+
+func TestToTimeSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Buckets: []float64{0.1, 1}})
+	h.Observe(0.5)
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "temp"}, []string{"site"})
+	g.WithLabelValues("lab").Set(21)
+	reg.MustRegister(h, g)
+	mfs, err := reg.Gather()
+	assert.NoError(t, err)
+	at := time.UnixMilli(1700000000000)
+
+	byName := seriesByName(toTimeSeries(mfs, at, map[string]string{"site": "home", "env": "prod"}))
+	// label of sample takes precedence over external one
+	assert.Equal(t, []rwSeries{{
+		labels: []rwLabel{{"__name__", "temp"}, {"env", "prod"}, {"site", "lab"}},
+		value:  21,
+		ts:     at.UnixMilli(),
+	}}, byName["temp"])
+	buckets := map[string]float64{}
+	for _, s := range byName["latency_bucket"] {
+		assert.Equal(t, "le", s.labels[2].name)
+		buckets[s.labels[2].value] = s.value
+	}
+	assert.Equal(t, map[string]float64{"0.1": 0, "1": 1, "+Inf": 1}, buckets)
+	assert.Equal(t, 0.5, byName["latency_sum"][0].value)
+	assert.Equal(t, 1.0, byName["latency_count"][0].value)
+
+	decoded := decodeWriteRequest(t, encodeWriteRequest(byName["temp"]))
+	assert.Equal(t, byName["temp"], decoded)
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		requests int
+		batches  [][]rwSeries
+	)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		// endpoint is down for first request, batch stays in queue
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, remoteWriteVersion, r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		data, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, data)
+		assert.NoError(t, err)
+		batches = append(batches, decodeWriteRequest(t, data))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer stub.Close()
+
+	dir := t.TempDir()
+	e := newTestExporter(&internal.ConfigSpec{
+		Devices: internal.DevicesContainer{"dev1": {Address: "127.0.0.1"}},
+		RemoteWrite: &internal.RemoteWriteSpec{
+			Url:            stub.URL,
+			BearerToken:    "secret",
+			Interval:       20 * time.Millisecond,
+			QueueDir:       dir,
+			ExternalLabels: &map[string]string{"site": "home"},
+		},
+	}, map[string]internal.Client{"dev1": &fakeClient{}})
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(batches) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	lock.Lock()
+	defer lock.Unlock()
+	// batch queued during outage is sent first
+	power := seriesByName(batches[0])["tuya_smartplug_power"]
+	assert.Len(t, power, 1)
+	assert.Equal(t, []rwLabel{{"__name__", "tuya_smartplug_power"}, {"device", "dev1"}, {"site", "home"}}, power[0].labels)
+	assert.Equal(t, 10.0, power[0].value)
+	assert.Less(t, power[0].ts, seriesByName(batches[1])["tuya_smartplug_power"][0].ts)
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.m.SinkFailures.WithLabelValues(sinkRemoteWrite)))
+	assert.Equal(t, float64(len(batches[0])+len(batches[1])), testutil.ToFloat64(e.m.SinkWritten.WithLabelValues(sinkRemoteWrite)))
+}
+
+func TestRemoteWriteQueue(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unable to parse", int(status.Load()))
+	}))
+	defer stub.Close()
+	m := newCommonMetrics()
+	s := &remoteWriteSink{
+		spec:   internal.RemoteWriteSpec{Url: stub.URL, QueueDir: t.TempDir(), QueueSize: 2},
+		client: stub.Client(),
+		m:      m,
+		l:      slog.New(slog.DiscardHandler),
+	}
+	series := []rwSeries{{labels: []rwLabel{{"__name__", "up"}}, value: 1, ts: 1}}
+	for range 3 {
+		assert.NoError(t, s.enqueue(series))
+	}
+	// the oldest batch is dropped when queue is full
+	assert.Len(t, s.queued(), 2)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.SinkDropped.WithLabelValues(sinkRemoteWrite)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.SinkQueueLength.WithLabelValues(sinkRemoteWrite)))
+
+	// retriable failure keeps batches on disk
+	s.drain(t.Context())
+	assert.Len(t, s.queued(), 2)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.SinkFailures.WithLabelValues(sinkRemoteWrite)))
+
+	// rejected batches are dropped
+	status.Store(http.StatusBadRequest)
+	s.drain(t.Context())
+	assert.Empty(t, s.queued())
+	entries, _ := os.ReadDir(s.spec.QueueDir)
+	assert.Empty(t, entries)
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.SinkDropped.WithLabelValues(sinkRemoteWrite)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.SinkQueueLength.WithLabelValues(sinkRemoteWrite)))
+}
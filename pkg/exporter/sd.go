@@ -0,0 +1,60 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/samber/lo"
+)
+
+// sdLabelPrefix is prefix of target labels. Metrics of device already carry device and extra labels,
+// target labels of the same names would clash with them, so they are published as meta labels, available for relabeling.
+const sdLabelPrefix = "__meta_tuya_"
+
+// TargetGroup is single entry of Prometheus HTTP service discovery response.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+func (e *exporter) Targets() []TargetGroup {
+	e.cfgLock.RLock()
+	defer e.cfgLock.RUnlock()
+	out := make([]TargetGroup, 0, len(e.cfg.Devices))
+	for _, dname := range slices.Sorted(maps.Keys(e.cfg.Devices)) {
+		dc := e.cfg.Devices[dname]
+		labels := map[string]string{
+			sdLabelPrefix + "device":  dname,
+			sdLabelPrefix + "address": dc.Address,
+		}
+		if e.discovering() {
+			// address announced by device is more accurate than configured one
+			e.lock.Lock()
+			if ds, ok := e.state[dname]; ok && ds.address != "" {
+				labels[sdLabelPrefix+"address"] = ds.address
+			}
+			e.lock.Unlock()
+		}
+		for _, ln := range e.cfg.ExtraLabelNames() {
+			labels[sdLabelPrefix+"label_"+e.cfg.ExportedLabelName(ln)] = lo.FromPtr(dc.ExtraLabels)[ln]
+		}
+		out = append(out, TargetGroup{Targets: []string{dname}, Labels: labels})
+	}
+	return out
+}
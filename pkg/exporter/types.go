@@ -17,11 +17,29 @@ limitations under the License.
 package exporter
 
 import (
+	"context"
+	"net/url"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rkosegi/tuya-smartplug-exporter/pkg/internal"
 )
 
+type Exporter interface {
+	prometheus.Collector
+	// Run performs background polling of devices, if it's enabled in configuration.
+	// It blocks until context is cancelled and all sinks finished writing remaining data.
+	Run(ctx context.Context)
+	// Reload applies new configuration. Clients of devices which configuration did not change are kept intact.
+	Reload(cfg *internal.ConfigSpec)
+	// Probe queries single device and returns its metrics along with probe_success and probe_duration_seconds.
+	// Device is either configured one, given by "device" parameter, or device given by "target" address,
+	// "id", "profile" and "credentials" parameters. Error is returned when parameters are invalid.
+	Probe(params url.Values) (prometheus.Gatherer, error)
+	// Targets lists configured devices in format of Prometheus HTTP service discovery, ordered by name.
+	Targets() []TargetGroup
+}
+
 type PlugInfo struct {
 	Voltage float64
 	Power   float64
@@ -38,7 +56,7 @@ type Device struct {
 }
 
 type DeviceMetrics struct {
-	ScrapeDuration *prometheus.SummaryVec
+	ScrapeDuration *prometheus.Desc
 	ScrapeErrors   *prometheus.CounterVec
 	Current        *prometheus.GaugeVec
 	Voltage        *prometheus.GaugeVec
@@ -48,9 +66,47 @@ type DeviceMetrics struct {
 	ReadPackets    *prometheus.CounterVec
 	SentErrors     *prometheus.CounterVec
 	ReadErrors     *prometheus.CounterVec
+	ReadingAge     *prometheus.GaugeVec
+	BreakerState   *prometheus.GaugeVec
+	AddressChanges *prometheus.CounterVec
 }
 
 type GlobalMetrics struct {
-	TotalScrapes prometheus.Summary
-	Error        prometheus.Gauge
+	TotalScrapes    prometheus.Summary
+	Error           prometheus.Gauge
+	SinkQueueLength *prometheus.GaugeVec
+	SinkWritten     *prometheus.CounterVec
+	SinkDropped     *prometheus.CounterVec
+	SinkFailures    *prometheus.CounterVec
+}
+
+// reading is outcome of single device query
+type reading struct {
+	status *internal.DpQueryResponse
+	// status decoded using profile of device, set only when query succeeded
+	plug   internal.PlugReading
+	stats  *internal.ProtoStats
+	err    error
+	reason string
+	// when query finished
+	at time.Time
+	// how long query took
+	took time.Duration
+}
+
+type deviceState struct {
+	last     *reading
+	lastGood *reading
+	// error count by reason
+	errors map[string]int
+	// nil unless circuit breaker is configured
+	breaker *breaker
+	// last address device was connected to, only tracked when discovery is enabled
+	address        string
+	addressChanges int
+	// energy used since device was first queried, estimated from power readings, in kWh
+	energy float64
+	// number of queries of device and their total duration
+	queries   uint64
+	queryTime time.Duration
 }
@@ -17,6 +17,8 @@ limitations under the License.
 package internal
 
 import (
+	"bytes"
+	"context"
 	"io"
 	"log/slog"
 	"net"
@@ -24,6 +26,7 @@ import (
 	"time"
 
 	"github.com/rkosegi/tuya-proto/proto"
+	"github.com/samber/lo"
 )
 
 type Client interface {
@@ -48,8 +51,9 @@ type clientImpl struct {
 	ver         proto.Version
 	clientNonce []byte
 	deviceNonce []byte
-	host        string
-	port        string
+	addr        string
+	resolve     func() string
+	dial        DialFunc
 	stats       ProtoStats
 }
 
@@ -66,22 +70,25 @@ func (c *clientImpl) afterConnect() error {
 		}
 		c.l.Debug("session negotiation step1")
 		if err = c.sendPacket(pkt); err != nil {
-			return err
+			return opErr(OpHandshake, err)
 		}
 		c.l.Debug("session negotiation step2")
 		if err = c.readPacket(pkt); err != nil {
-			return err
+			return opErr(OpHandshake, err)
 		}
 		c.l.Debug("session negotiation step3")
+		if len(pkt.DecryptedPayload) < 16 {
+			return opErr(OpHandshake, ErrShortPayload)
+		}
 		c.deviceNonce = pkt.DecryptedPayload[:16]
 		if pkt, err = c.mb.SessKeyNegFinish(c.key, c.deviceNonce, c.seqNo.Add(1)); err != nil {
-			return err
+			return opErr(OpHandshake, err)
 		}
 		if err = c.sendPacket(pkt); err != nil {
-			return err
+			return opErr(OpHandshake, err)
 		}
 		if c.key, err = c.mb.MakeSessionKey(c.clientNonce, c.deviceNonce, c.key); err != nil {
-			return err
+			return opErr(OpHandshake, err)
 		}
 	}
 	return nil
@@ -98,9 +105,9 @@ func (c *clientImpl) Send(cmd proto.CmdIdType, obj any) error {
 	}
 	_, err := pkt.Encode(c.key)
 	if err != nil {
-		return err
+		return opErr(OpSend, err)
 	}
-	return c.sendPacket(pkt)
+	return opErr(OpSend, c.sendPacket(pkt))
 }
 
 func (c *clientImpl) Read(dest any) (err error) {
@@ -109,7 +116,21 @@ func (c *clientImpl) Read(dest any) (err error) {
 		return err
 	}
 	c.l.Debug("payload decoded", "payload", string(pkt.DecryptedPayload))
-	return pkt.GetJsonPayload(dest)
+	if len(pkt.DecryptedPayload) == 0 {
+		return opErr(OpDecode, ErrShortPayload)
+	}
+	if err = pkt.GetJsonPayload(dest); err != nil {
+		data := pkt.DecryptedPayload
+		if bytes.HasPrefix(data, []byte(c.ver.String())) && len(data) > 15 {
+			// same as proto.Packet.GetJsonPayload, skip version header
+			data = data[15:]
+		}
+		if !isPrintable(data) {
+			return opErr(OpDecode, ErrBadKey)
+		}
+		return opErr(OpDecode, err)
+	}
+	return nil
 }
 
 func (c *clientImpl) Close() error {
@@ -132,16 +153,28 @@ func (c *clientImpl) sendPacket(pkt *proto.Packet) error {
 	return err
 }
 
-func (c *clientImpl) readPacket(pkt *proto.Packet) error {
+func (c *clientImpl) readPacket(pkt *proto.Packet) (err error) {
 	_ = c.conn.SetReadDeadline(time.Now().Add(c.rto))
 	buf := make([]byte, 4096)
-	_, err := c.conn.Read(buf)
+	_, err = c.conn.Read(buf)
 	c.stats.ReadPkts++
 	if err != nil {
 		c.stats.ReadErrs++
-		return err
+		return opErr(OpRead, err)
+	}
+	defer func() {
+		// decryption with wrong key can yield invalid padding, which makes decoder panic
+		if r := recover(); r != nil {
+			err = opErr(OpDecode, ErrBadKey)
+		}
+	}()
+	if err = pkt.Decode(buf, c.key); err != nil {
+		return opErr(OpDecode, err)
+	}
+	if c.ver == proto.Version34 && !pkt.ChecksumValid {
+		return opErr(OpDecode, ErrBadKey)
 	}
-	return pkt.Decode(buf, c.key)
+	return nil
 }
 
 type Opt func(*clientImpl)
@@ -164,6 +197,14 @@ func WithWriteTimeout(t time.Duration) Opt {
 	}
 }
 
+// WithResolver sets function that provides current address of device prior to every connection attempt.
+// Static address is used when function returns empty string.
+func WithResolver(r func() string) Opt {
+	return func(c *clientImpl) {
+		c.resolve = r
+	}
+}
+
 func WithLogger(l *slog.Logger) Opt {
 	return func(c *clientImpl) {
 		c.l = l
@@ -190,22 +231,70 @@ func NewClient(ver proto.Version, addr string, key []byte, opts ...Opt) Client {
 	}, opts...) {
 		opt(c)
 	}
-	var err error
-	if c.host, c.port, err = net.SplitHostPort(addr); err != nil {
-		c.host = addr
-		c.port = "6668"
+	c.addr = addr
+	if c.dial == nil {
+		c.dial = (&net.Dialer{}).DialContext
 	}
-
 	return c
 }
 
+// protoVersion maps protocol name used in configuration to protocol version.
+func protoVersion(protocol string) proto.Version {
+	if protocol == "tuya3.4" {
+		return proto.Version34
+	}
+	return proto.Version31
+}
+
+// NewDeviceClient creates client of device using its connection settings. Given options take precedence.
+func NewDeviceClient(dc DeviceConnectionSpec, opts ...Opt) Client {
+	return NewClient(protoVersion(dc.Protocol), dc.Address, []byte(dc.Key), append([]Opt{
+		WithTimeout(dc.ConnectTimeout),
+		WithReadTimeout(dc.ReadTimeout),
+		WithWriteTimeout(dc.WriteTimeout),
+		WithDialer(deviceDialer(dc)),
+	}, opts...)...)
+}
+
+// deviceDialer returns function that connects to device according to its dialer settings.
+// Settings are validated along with configuration, should they be invalid anyway, every connection attempt fails.
+func deviceDialer(dc DeviceConnectionSpec) DialFunc {
+	d, err := NewDialer(dc.Dialer, dc.ConnectTimeout)
+	if err != nil {
+		return func(context.Context, string, string) (net.Conn, error) {
+			return nil, err
+		}
+	}
+	return d
+}
+
 func (c *clientImpl) Connect() error {
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, c.port), c.to)
+	addr := c.addr
+	if c.resolve != nil {
+		addr = lo.CoalesceOrEmpty(c.resolve(), addr)
+	}
+	if addr == "" {
+		return opErr(OpConnect, ErrNoAddress)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "6668")
+	}
+	ctx := context.Background()
+	if c.to > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.to)
+		defer cancel()
+	}
+	conn, err := c.dial(ctx, "tcp", addr)
 	if err != nil {
-		return err
+		return opErr(OpConnect, err)
 	}
 	c.conn = conn
-	return c.afterConnect()
+	if err = c.afterConnect(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
 }
 
 func (c *clientImpl) Stats() ProtoStats {
@@ -0,0 +1,154 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rkosegi/tuya-proto/proto"
+)
+
+// version header preceding payload of control command in protocol 3.4
+var controlHeader34 = "3.4" + strings.Repeat("\x00", 12)
+
+// statusPush is status reported by device. Protocol 3.4 wraps data points into data object.
+type statusPush struct {
+	Dps  map[string]any `json:"dps"`
+	Data struct {
+		Dps map[string]any `json:"dps"`
+	} `json:"data"`
+}
+
+// sendRequest sends command which payload is either empty (protocol 3.4) or identifies device.
+func sendRequest(cl Client, dc DeviceConnectionSpec, cmd proto.CmdIdType) error {
+	if dc.Protocol == "tuya3.4" {
+		return cl.Send(cmd, make(map[string]any))
+	}
+	return cl.Send(cmd, DpQueryRequest{
+		GwId:  dc.Id,
+		DevId: dc.Id,
+	})
+}
+
+func queryCmd(dc DeviceConnectionSpec) proto.CmdIdType {
+	if dc.Protocol == "tuya3.4" {
+		return proto.CmdIdTypeDpQueryNew
+	}
+	return proto.CmdIdTypeDpQuery
+}
+
+// QueryDps queries current value of all data points of device. Connection is closed afterward.
+func QueryDps(cl Client, dc DeviceConnectionSpec) (*DpQueryResponse, error) {
+	if !cl.IsConnected() {
+		if err := cl.Connect(); err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		_ = cl.Close()
+	}()
+	if err := sendRequest(cl, dc, queryCmd(dc)); err != nil {
+		return nil, err
+	}
+	var out DpQueryResponse
+	if err := cl.Read(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// WatchDps keeps connection to device open and calls fn with current status, followed by every status pushed by device,
+// until context is cancelled or error occurs. When device is silent for read timeout, heartbeat is sent to keep connection alive.
+// Connection is closed afterward.
+func WatchDps(ctx context.Context, cl Client, dc DeviceConnectionSpec, fn func(*DpQueryResponse)) error {
+	if !cl.IsConnected() {
+		if err := cl.Connect(); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		_ = cl.Close()
+	}()
+	if err := sendRequest(cl, dc, queryCmd(dc)); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		var sp statusPush
+		err := cl.Read(&sp)
+		switch {
+		case err == nil:
+			dps := sp.Dps
+			if len(dps) == 0 {
+				dps = sp.Data.Dps
+			}
+			if len(dps) > 0 {
+				fn(&DpQueryResponse{Dps: dps})
+			}
+		case errors.Is(err, ErrShortPayload):
+			// acknowledgement of heartbeat
+		case isTimeout(err):
+			if err = sendRequest(cl, dc, proto.CmdIdTypeHeartBeat); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDps changes value of data points of device. Connection is closed afterward.
+func SetDps(cl Client, dc DeviceConnectionSpec, dps map[string]any) (err error) {
+	if !cl.IsConnected() {
+		if err = cl.Connect(); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		_ = cl.Close()
+	}()
+	t := time.Now().Unix()
+	if dc.Protocol == "tuya3.4" {
+		data, _ := json.Marshal(map[string]any{
+			"protocol": 5,
+			"t":        t,
+			"data":     map[string]any{"dps": dps},
+		})
+		err = cl.Send(proto.CmdIdTypeControlNew, controlHeader34+string(data))
+	} else {
+		err = cl.Send(proto.CmdIdTypeControl, DpControlRequest{
+			DevId: dc.Id,
+			Uid:   dc.Id,
+			T:     strconv.FormatInt(t, 10),
+			Dps:   dps,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	// device either acknowledges command with empty payload or responds with new status
+	var out DpQueryResponse
+	if err = cl.Read(&out); err != nil && !errors.Is(err, ErrShortPayload) {
+		return err
+	}
+	return nil
+}
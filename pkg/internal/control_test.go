@@ -0,0 +1,96 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/rkosegi/tuya-proto/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptedClient responds to reads with given payloads or errors, in order.
+type scriptedClient struct {
+	connected bool
+	sent      []proto.CmdIdType
+	responses []any
+}
+
+func (s *scriptedClient) Close() error {
+	s.connected = false
+	return nil
+}
+
+func (s *scriptedClient) Read(dest any) error {
+	if len(s.responses) == 0 {
+		return opErr(OpRead, errors.New("connection reset"))
+	}
+	r := s.responses[0]
+	s.responses = s.responses[1:]
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return json.Unmarshal([]byte(r.(string)), dest)
+}
+
+func (s *scriptedClient) Send(cmd proto.CmdIdType, _ any) error {
+	s.sent = append(s.sent, cmd)
+	return nil
+}
+
+func (s *scriptedClient) Connect() error {
+	s.connected = true
+	return nil
+}
+
+func (s *scriptedClient) IsConnected() bool {
+	return s.connected
+}
+
+func (s *scriptedClient) Stats() ProtoStats {
+	return ProtoStats{}
+}
+
+func TestQueryDps(t *testing.T) {
+	cl := &scriptedClient{responses: []any{`{"dps":{"1":true}}`}}
+	out, err := QueryDps(cl, DeviceConnectionSpec{Protocol: "tuya3.1", Id: "abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"1": true}, out.Dps)
+	assert.Equal(t, []proto.CmdIdType{proto.CmdIdTypeDpQuery}, cl.sent)
+	assert.False(t, cl.connected)
+}
+
+func TestWatchDps(t *testing.T) {
+	cl := &scriptedClient{responses: []any{
+		`{"dps":{"1":true,"19":100}}`,
+		opErr(OpRead, os.ErrDeadlineExceeded),
+		opErr(OpDecode, ErrShortPayload),
+		`{"protocol":4,"t":1700000000,"data":{"dps":{"1":false}}}`,
+	}}
+	var got []map[string]any
+	err := WatchDps(t.Context(), cl, DeviceConnectionSpec{Protocol: "tuya3.4"}, func(r *DpQueryResponse) {
+		got = append(got, r.Dps)
+	})
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, []map[string]any{{"1": true, "19": 100.0}, {"1": false}}, got)
+	// heartbeat is sent when device is silent
+	assert.Equal(t, []proto.CmdIdType{proto.CmdIdTypeDpQueryNew, proto.CmdIdTypeHeartBeat}, cl.sent)
+	assert.False(t, cl.connected)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	DefaultProtocol = "tuya3.1"
+	DefaultTimeout  = 10 * time.Second
+)
+
+var knownProtocols = []string{"tuya3.1", "tuya3.4"}
+
+// ApplyDefaults fills unset device settings, first from defaults section, then from built-in values.
+func (c *ConfigSpec) ApplyDefaults() {
+	d := DeviceDefaultsSpec{}
+	if c.Defaults != nil {
+		d = *c.Defaults
+	}
+	for name, dc := range c.Devices {
+		dc.Protocol = lo.CoalesceOrEmpty(dc.Protocol, d.Protocol, DefaultProtocol)
+		dc.Profile = lo.CoalesceOrEmpty(dc.Profile, d.Profile, DefaultProfile)
+		dc.ConnectTimeout = lo.CoalesceOrEmpty(dc.ConnectTimeout, d.ConnectTimeout, DefaultTimeout)
+		dc.ReadTimeout = lo.CoalesceOrEmpty(dc.ReadTimeout, d.ReadTimeout, DefaultTimeout)
+		dc.WriteTimeout = lo.CoalesceOrEmpty(dc.WriteTimeout, d.WriteTimeout, DefaultTimeout)
+		// zero means global polling interval
+		dc.PollInterval = lo.CoalesceOrEmpty(dc.PollInterval, d.PollInterval)
+		// device gets its own copy, as secrets within are expanded per device
+		if dc.Dialer == nil && d.Dialer != nil {
+			dc.Dialer = new(*d.Dialer)
+		}
+		if d.ExtraLabels != nil {
+			labels := maps.Clone(*d.ExtraLabels)
+			if dc.ExtraLabels != nil {
+				maps.Copy(labels, *dc.ExtraLabels)
+			}
+			dc.ExtraLabels = &labels
+		}
+		c.Devices[name] = dc
+	}
+	if c.Credentials != nil {
+		for name, cs := range *c.Credentials {
+			cs.Protocol = lo.CoalesceOrEmpty(cs.Protocol, d.Protocol, DefaultProtocol)
+			(*c.Credentials)[name] = cs
+		}
+	}
+}
+
+// Validate performs checks that can't be expressed using JSON schema.
+// Defaults should be applied prior calling this function.
+func (c *ConfigSpec) Validate() error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(c.Devices)) {
+		dc := c.Devices[name]
+		if dc.Address == "" && c.Discovery == nil {
+			errs = append(errs, &PathError{Path: "/devices/" + name + "/address",
+				Message: "address is required unless discovery is enabled"})
+		}
+		if !slices.Contains(knownProtocols, dc.Protocol) {
+			errs = append(errs, &PathError{Path: "/devices/" + name + "/protocol",
+				Message: fmt.Sprintf("unknown protocol '%s', must be one of %v", dc.Protocol, knownProtocols)})
+		}
+		if _, ok := LookupProfile(dc.Profile); !ok {
+			errs = append(errs, &PathError{Path: "/devices/" + name + "/profile",
+				Message: fmt.Sprintf("unknown profile '%s', must be one of %v", dc.Profile, ProfileNames())})
+		}
+		errs = append(errs, validateDialer("/devices/"+name+"/dialer", dc.Dialer)...)
+	}
+	if c.Defaults != nil {
+		errs = append(errs, validateDialer("/defaults/dialer", c.Defaults.Dialer)...)
+	}
+	if c.Credentials != nil {
+		for _, name := range slices.Sorted(maps.Keys(*c.Credentials)) {
+			if p := (*c.Credentials)[name].Protocol; !slices.Contains(knownProtocols, p) {
+				errs = append(errs, &PathError{Path: "/credentials/" + name + "/protocol",
+					Message: fmt.Sprintf("unknown protocol '%s', must be one of %v", p, knownProtocols)})
+			}
+		}
+	}
+	if rw := c.RemoteWrite; rw != nil {
+		if rw.BasicAuth != nil && (rw.BasicAuth.Password == "") == (rw.BasicAuth.PasswordFile == "") {
+			errs = append(errs, &PathError{Path: "/remoteWrite/basicAuth",
+				Message: "exactly one of password or passwordFile must be set"})
+		}
+		if rw.BearerToken != "" && rw.BearerTokenFile != "" {
+			errs = append(errs, &PathError{Path: "/remoteWrite/bearerToken",
+				Message: "at most one of bearerToken or bearerTokenFile can be set"})
+		}
+		if rw.BasicAuth != nil && (rw.BearerToken != "" || rw.BearerTokenFile != "") {
+			errs = append(errs, &PathError{Path: "/remoteWrite/basicAuth",
+				Message: "basic authentication and bearer token can't be used together"})
+		}
+	}
+	errs = append(errs, c.ValidateLabels())
+	return errors.Join(errs...)
+}
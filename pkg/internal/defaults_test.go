@@ -0,0 +1,73 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	cfg := &ConfigSpec{
+		Defaults: &DeviceDefaultsSpec{
+			Protocol:     "tuya3.4",
+			ReadTimeout:  3 * time.Second,
+			PollInterval: time.Minute,
+			ExtraLabels:  &map[string]string{"room": "unknown", "phase": "L1"},
+			Dialer:       &DialerSpec{SourceAddress: "10.20.0.2"},
+		},
+		ExtraDeviceLabels: &ExtraDeviceLabels{"room", "phase"},
+		Devices: DevicesContainer{
+			"plug-1": {Address: "192.168.1.5", ReadTimeout: 5 * time.Second,
+				ExtraLabels: &map[string]string{"room": "kitchen"}},
+			"plug-2": {Address: "192.168.1.6", Protocol: "tuya3.1", Profile: "default",
+				Dialer: &DialerSpec{Socks5Proxy: "socks5://proxy:1080"}},
+		},
+	}
+	cfg.ApplyDefaults()
+	assert.NoError(t, cfg.Validate())
+
+	p1 := cfg.Devices["plug-1"]
+	assert.Equal(t, "tuya3.4", p1.Protocol)
+	assert.Equal(t, DefaultProfile, p1.Profile)
+	assert.Equal(t, 5*time.Second, p1.ReadTimeout)
+	assert.Equal(t, DefaultTimeout, p1.ConnectTimeout)
+	assert.Equal(t, time.Minute, p1.PollInterval)
+	assert.Equal(t, map[string]string{"room": "kitchen", "phase": "L1"}, *p1.ExtraLabels)
+	assert.Equal(t, DialerSpec{SourceAddress: "10.20.0.2"}, *p1.Dialer)
+	assert.NotSame(t, cfg.Defaults.Dialer, p1.Dialer)
+
+	p2 := cfg.Devices["plug-2"]
+	assert.Equal(t, "tuya3.1", p2.Protocol)
+	assert.Equal(t, "default", p2.Profile)
+	assert.Equal(t, 3*time.Second, p2.ReadTimeout)
+	assert.Equal(t, map[string]string{"room": "unknown", "phase": "L1"}, *p2.ExtraLabels)
+	assert.Equal(t, DialerSpec{Socks5Proxy: "socks5://proxy:1080"}, *p2.Dialer)
+
+	p2.Profile = "toaster"
+	cfg.Devices["plug-2"] = p2
+	assert.ErrorContains(t, cfg.Validate(), "/devices/plug-2/profile: unknown profile 'toaster'")
+
+	delete(cfg.Devices, "plug-2")
+	p1.Address = ""
+	cfg.Devices["plug-1"] = p1
+	assert.ErrorContains(t, cfg.Validate(), "/devices/plug-1/address: address is required unless discovery is enabled")
+	cfg.Discovery = &DiscoverySpec{}
+	assert.NoError(t, cfg.Validate())
+}
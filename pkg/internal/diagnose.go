@@ -0,0 +1,231 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	StepOk      = "ok"
+	StepFailed  = "failed"
+	StepSkipped = "skipped"
+
+	defaultDevicePort = "6668"
+)
+
+// DiagnosticStep is outcome of single step of device diagnosis.
+type DiagnosticStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// time spent by step, in milliseconds
+	DurationMs float64 `json:"durationMs"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	// one of error reasons, as used by scrape_errors_total
+	Reason string `json:"reason,omitempty"`
+	// plain-language explanation of failure
+	Hint string `json:"hint,omitempty"`
+}
+
+// Diagnosis is report of all steps performed while diagnosing connectivity to device.
+type Diagnosis struct {
+	Address  string           `json:"address"`
+	Protocol string           `json:"protocol"`
+	Steps    []DiagnosticStep `json:"steps"`
+	// whether device was queried successfully using its settings
+	Ok bool `json:"ok"`
+}
+
+// add records outcome of step and returns its index.
+func (d *Diagnosis) add(name string, start time.Time, detail string, err error, hint string) int {
+	step := DiagnosticStep{
+		Name:       name,
+		Status:     StepOk,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:     detail,
+	}
+	if err != nil {
+		step.Status = StepFailed
+		step.Error = err.Error()
+		step.Hint = hint
+		var oe *OpError
+		if errors.As(err, &oe) {
+			step.Reason = ErrorReason(err)
+		}
+	}
+	d.Steps = append(d.Steps, step)
+	return len(d.Steps) - 1
+}
+
+func (d *Diagnosis) skip(name, detail string) {
+	d.Steps = append(d.Steps, DiagnosticStep{Name: name, Status: StepSkipped, Detail: detail})
+}
+
+// skipRest records steps that need connection as skipped.
+func (d *Diagnosis) skipRest() {
+	d.skip("key", "")
+	for _, p := range knownProtocols {
+		d.skip("negotiate "+p, "")
+	}
+	d.skip("query", "")
+}
+
+// hintFor explains error returned by client in plain language.
+func hintFor(err error) string {
+	switch ErrorReason(err) {
+	case ReasonConnectRefused:
+		return "Nothing accepts connections on this address. Device accepts single connection only, " +
+			"make sure no other client (such as Tuya app or another integration) is connected, or power cycle device."
+	case ReasonConnectTimeout:
+		return "Device doesn't respond. Check that it's powered on and reachable from this host (VLAN, firewall, routing)."
+	case ReasonAddressUnknown:
+		return "Address of device is not known, configure it or use --address."
+	case ReasonHandshakeFailed:
+		return "Device didn't complete session negotiation, it probably uses different protocol version."
+	case ReasonBadKey:
+		return "Payload can't be decrypted, local key is wrong. Key changes whenever device is paired again, obtain it again."
+	case ReasonReadTimeout:
+		return "Device accepted connection, but didn't respond. Protocol version or device id is probably wrong."
+	case ReasonDecodeError:
+		return "Device responded with payload that is not valid JSON, protocol version is probably wrong."
+	}
+	return "Device responded unexpectedly, run with --log.level=debug to see payloads."
+}
+
+// Diagnose walks through connectivity to device step by step: resolves its address, connects to it, checks local key,
+// attempts session negotiation using every protocol version and queries device.
+// Steps that depend on failed step are skipped.
+func Diagnose(ctx context.Context, dc DeviceConnectionSpec, l *slog.Logger) *Diagnosis {
+	d := &Diagnosis{Address: dc.Address, Protocol: dc.Protocol}
+
+	start := time.Now()
+	host, port, err := net.SplitHostPort(dc.Address)
+	if err != nil {
+		host, port = dc.Address, defaultDevicePort
+	}
+	var addrs []string
+	if host == "" {
+		err = opErr(OpConnect, ErrNoAddress)
+	} else {
+		rctx, cancel := context.WithTimeout(ctx, dc.ConnectTimeout)
+		addrs, err = net.DefaultResolver.LookupHost(rctx, host)
+		cancel()
+	}
+	hint := "Name can't be resolved, check address of device and DNS settings."
+	if errors.Is(err, ErrNoAddress) {
+		hint = hintFor(err)
+	}
+	d.add("resolve", start, strings.Join(addrs, ", "), err, hint)
+	if err != nil {
+		d.skip("connect", "address is not resolved")
+		d.skipRest()
+		return d
+	}
+
+	target := net.JoinHostPort(addrs[0], port)
+	start = time.Now()
+	conn, err := deviceDialer(dc)(ctx, "tcp", target)
+	if err == nil {
+		_ = conn.Close()
+	} else {
+		err = opErr(OpConnect, err)
+	}
+	d.add("connect", start, target, err, hintFor(err))
+	if err != nil {
+		d.skipRest()
+		return d
+	}
+
+	// payload can't be encrypted at all using key of wrong size
+	start = time.Now()
+	err = nil
+	if n := len(dc.Key); n != keyLen {
+		err = fmt.Errorf("local key has %d characters, %d expected", n, keyLen)
+	}
+	d.add("key", start, "", err, "Local key is 16 characters long, make sure it was copied completely.")
+	if err != nil {
+		for _, p := range knownProtocols {
+			d.skip("negotiate "+p, "local key is invalid")
+		}
+		d.skip("query", "local key is invalid")
+		return d
+	}
+
+	negotiated := ""
+	configured := -1
+	for _, p := range knownProtocols {
+		pdc := dc
+		pdc.Address = target
+		pdc.Protocol = p
+		cl := NewDeviceClient(pdc, WithLogger(l.With("address", target, "protocol", p)))
+		start = time.Now()
+		err = cl.Connect()
+		_ = cl.Close()
+		detail := "session key negotiated"
+		if p != "tuya3.4" {
+			detail = "protocol has no session negotiation, connection only"
+		} else if err == nil {
+			negotiated = p
+		}
+		step := d.add("negotiate "+p, start, detail, err, hintFor(err))
+		if p == dc.Protocol {
+			configured = step
+		}
+	}
+	failed := configured >= 0 && d.Steps[configured].Status == StepFailed
+	if failed && negotiated != "" {
+		d.Steps[configured].Hint = fmt.Sprintf("Device negotiated session using %s, set protocol of device to %s.", negotiated, negotiated)
+	}
+
+	if failed {
+		d.skip("query", "session can't be established using protocol "+dc.Protocol)
+		return d
+	}
+	qdc := dc
+	qdc.Address = target
+	cl := NewDeviceClient(qdc, WithLogger(l.With("address", target, "protocol", dc.Protocol)))
+	start = time.Now()
+	status, err := QueryDps(cl, qdc)
+	detail := ""
+	if err == nil {
+		detail = fmt.Sprintf("%d data points", len(status.Dps))
+		profile, _ := LookupProfile(dc.Profile)
+		var r PlugReading
+		if r, err = profile.Decode(status.Dps); err == nil {
+			detail += fmt.Sprintf(", switch on: %t, %g V, %g A, %g W", r.SwitchOn, r.Voltage, r.Current, r.Power)
+			d.Ok = true
+		} else {
+			err = opErr(OpDecode, err)
+		}
+	}
+	hint = hintFor(err)
+	if errors.Is(err, ErrBadDataPoint) {
+		hint = fmt.Sprintf("Device doesn't report readings using data points of profile '%s', run query command to see data points it uses.", dc.Profile)
+	}
+	if negotiated != "" && negotiated != dc.Protocol {
+		hint = fmt.Sprintf("Device negotiated session using %s, set protocol of device to %s.", negotiated, negotiated)
+	}
+	d.add("query", start, detail, err, hint)
+	return d
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stepStatuses(d *Diagnosis) map[string]string {
+	res := map[string]string{}
+	for _, s := range d.Steps {
+		res[s.Name] = s.Status
+	}
+	return res
+}
+
+func TestDiagnoseRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	d := Diagnose(t.Context(), DeviceConnectionSpec{
+		Address: addr, Protocol: "tuya3.1", ConnectTimeout: time.Second,
+	}, slog.New(slog.DiscardHandler))
+	assert.False(t, d.Ok)
+	assert.Equal(t, map[string]string{
+		"resolve":           StepOk,
+		"connect":           StepFailed,
+		"negotiate tuya3.1": StepSkipped,
+		"negotiate tuya3.4": StepSkipped,
+		"key":               StepSkipped,
+		"query":             StepSkipped,
+	}, stepStatuses(d))
+	assert.Equal(t, ReasonConnectRefused, d.Steps[1].Reason)
+	assert.NotEmpty(t, d.Steps[1].Hint)
+}
+
+func TestDiagnoseSilentDevice(t *testing.T) {
+	// accepts connections, but never responds
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	d := Diagnose(t.Context(), DeviceConnectionSpec{
+		Address:        ln.Addr().String(),
+		Id:             "abc",
+		Key:            "0123456789abcdef",
+		Protocol:       "tuya3.1",
+		ConnectTimeout: time.Second,
+		ReadTimeout:    50 * time.Millisecond,
+		WriteTimeout:   time.Second,
+	}, slog.New(slog.DiscardHandler))
+	assert.False(t, d.Ok)
+	assert.Equal(t, map[string]string{
+		"resolve":           StepOk,
+		"connect":           StepOk,
+		"negotiate tuya3.1": StepOk,
+		"negotiate tuya3.4": StepFailed,
+		"key":               StepOk,
+		"query":             StepFailed,
+	}, stepStatuses(d))
+	steps := map[string]DiagnosticStep{}
+	for _, s := range d.Steps {
+		steps[s.Name] = s
+	}
+	assert.Equal(t, ReasonHandshakeFailed, steps["negotiate tuya3.4"].Reason)
+	assert.Equal(t, ReasonReadTimeout, steps["query"].Reason)
+}
+
+func TestDiagnoseBadKey(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = ln.Close()
+	}()
+	d := Diagnose(t.Context(), DeviceConnectionSpec{
+		Address: ln.Addr().String(), Key: "0123456789abcde", Protocol: "tuya3.4", ConnectTimeout: time.Second,
+	}, slog.New(slog.DiscardHandler))
+	assert.False(t, d.Ok)
+	assert.Equal(t, map[string]string{
+		"resolve":           StepOk,
+		"connect":           StepOk,
+		"key":               StepFailed,
+		"negotiate tuya3.1": StepSkipped,
+		"negotiate tuya3.4": StepSkipped,
+		"query":             StepSkipped,
+	}, stepStatuses(d))
+	assert.Contains(t, d.Steps[2].Error, "15 characters")
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+var errSocketOptions = errors.New("interface and mark are supported on Linux only")
+
+// DialFunc establishes connection to address, such as net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// NewDialer creates function that connects to devices according to given settings.
+// Nil spec yields plain dialer using default routing.
+func NewDialer(spec *DialerSpec, timeout time.Duration) (DialFunc, error) {
+	d := &net.Dialer{Timeout: timeout}
+	if spec == nil {
+		return d.DialContext, nil
+	}
+	if spec.SourceAddress != "" {
+		ip, err := netip.ParseAddr(spec.SourceAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source address: %w", err)
+		}
+		d.LocalAddr = &net.TCPAddr{IP: ip.AsSlice(), Zone: ip.Zone()}
+	}
+	if spec.Interface != "" || spec.Mark != 0 {
+		if !socketOptionsSupported {
+			return nil, errSocketOptions
+		}
+		d.Control = socketControl(spec.Interface, spec.Mark)
+	}
+	if spec.Socks5Proxy == "" {
+		return d.DialContext, nil
+	}
+	u, err := parseProxyUrl(spec.Socks5Proxy)
+	if err != nil {
+		return nil, err
+	}
+	// connection to proxy itself originates from configured source
+	pd, err := proxy.FromURL(u, d)
+	if err != nil {
+		return nil, err
+	}
+	return pd.(proxy.ContextDialer).DialContext, nil
+}
+
+// parseProxyUrl parses address of SOCKS5 proxy.
+func parseProxyUrl(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.New("invalid SOCKS5 proxy address")
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported scheme '%s' of SOCKS5 proxy, must be socks5 or socks5h", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("host of SOCKS5 proxy is missing")
+	}
+	return u, nil
+}
+
+// validateDialer checks settings of dialer at given path of configuration document.
+func validateDialer(path string, spec *DialerSpec) []error {
+	if spec == nil {
+		return nil
+	}
+	var errs []error
+	if spec.SourceAddress != "" {
+		if _, err := netip.ParseAddr(spec.SourceAddress); err != nil {
+			errs = append(errs, &PathError{Path: path + "/sourceAddress",
+				Message: fmt.Sprintf("invalid IP address '%s'", spec.SourceAddress)})
+		}
+	}
+	if !socketOptionsSupported {
+		if spec.Interface != "" {
+			errs = append(errs, &PathError{Path: path + "/interface", Message: errSocketOptions.Error()})
+		}
+		if spec.Mark != 0 {
+			errs = append(errs, &PathError{Path: path + "/mark", Message: errSocketOptions.Error()})
+		}
+	}
+	if spec.Socks5Proxy != "" {
+		if _, err := parseProxyUrl(spec.Socks5Proxy); err != nil {
+			errs = append(errs, &PathError{Path: path + "/socks5Proxy", Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+// WithDialer sets function used to connect to device, such as one returned by NewDialer.
+func WithDialer(d DialFunc) Opt {
+	return func(c *clientImpl) {
+		c.dial = d
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+const socketOptionsSupported = true
+
+// socketControl binds socket to network interface and sets its firewall mark, whichever is given.
+func socketControl(iface string, mark int) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if iface != "" {
+				if serr = syscall.BindToDevice(int(fd), iface); serr != nil {
+					serr = fmt.Errorf("unable to bind to interface %s: %w", iface, serr)
+					return
+				}
+			}
+			if mark != 0 {
+				if serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark); serr != nil {
+					serr = fmt.Errorf("unable to set mark: %w", serr)
+				}
+			}
+		})
+		return errors.Join(err, serr)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rkosegi/tuya-proto/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDialer(t *testing.T) {
+	client, device := net.Pipe()
+	defer func() {
+		_ = device.Close()
+	}()
+	var dialed string
+	c := NewClient(proto.Version31, "plug-1", []byte("0123456789abcdef"),
+		WithDialer(func(_ context.Context, network, addr string) (net.Conn, error) {
+			dialed = network + "://" + addr
+			return client, nil
+		}))
+	assert.NoError(t, c.Connect())
+	assert.Equal(t, "tcp://plug-1:6668", dialed)
+
+	go func() {
+		_ = c.Send(proto.CmdIdTypeDpQuery, &DpQueryRequest{DevId: "abc"})
+	}()
+	buf := make([]byte, 4096)
+	n, err := device.Read(buf)
+	assert.NoError(t, err)
+	pkt := proto.Packet{Version: proto.Version31}
+	assert.NoError(t, pkt.Decode(buf[:n], []byte("0123456789abcdef")))
+	assert.Equal(t, proto.CmdIdTypeDpQuery, pkt.CmdId)
+	assert.NoError(t, c.Close())
+}
+
+func TestNewDialerSourceAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = ln.Close()
+	}()
+	dial, err := NewDialer(&DialerSpec{SourceAddress: "127.0.0.1"}, time.Second)
+	assert.NoError(t, err)
+	conn, err := dial(t.Context(), "tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+	assert.NoError(t, conn.Close())
+}
+
+// serveSocks5 accepts single connection, completes SOCKS5 handshake without authentication and reports requested target.
+func serveSocks5(t *testing.T, ln net.Listener, target chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	// version, number of methods, no authentication
+	greeting := make([]byte, 3)
+	if _, err = io.ReadFull(conn, greeting); err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = conn.Write([]byte{5, 0})
+	// version, connect, reserved, IPv4, address, port
+	req := make([]byte, 10)
+	if _, err = io.ReadFull(conn, req); err != nil {
+		t.Error(err)
+		return
+	}
+	target <- net.JoinHostPort(net.IP(req[4:8]).String(), fmt.Sprint(int(req[8])<<8|int(req[9])))
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+func TestNewDialerSocks5(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = ln.Close()
+	}()
+	target := make(chan string, 1)
+	go serveSocks5(t, ln, target)
+	dial, err := NewDialer(&DialerSpec{Socks5Proxy: "socks5://" + ln.Addr().String()}, time.Second)
+	assert.NoError(t, err)
+	conn, err := dial(t.Context(), "tcp", "10.20.0.5:6668")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.0.5:6668", <-target)
+	assert.NoError(t, conn.Close())
+}
+
+func TestValidateDialer(t *testing.T) {
+	assert.Empty(t, validateDialer("/defaults/dialer", nil))
+	assert.Empty(t, validateDialer("/defaults/dialer", &DialerSpec{SourceAddress: "10.20.0.2", Socks5Proxy: "socks5://u:p@proxy:1080"}))
+	errs := validateDialer("/devices/plug-1/dialer", &DialerSpec{SourceAddress: "10.20.0", Socks5Proxy: "http://proxy:3128"})
+	assert.Len(t, errs, 2)
+	assert.EqualError(t, errs[0], "/devices/plug-1/dialer/sourceAddress: invalid IP address '10.20.0'")
+	assert.EqualError(t, errs[1], "/devices/plug-1/dialer/socks5Proxy: unsupported scheme 'http' of SOCKS5 proxy, must be socks5 or socks5h")
+	_, err := NewDialer(&DialerSpec{Socks5Proxy: "socks5://"}, time.Second)
+	assert.EqualError(t, err, "host of SOCKS5 proxy is missing")
+}
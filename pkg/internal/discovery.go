@@ -0,0 +1,190 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkosegi/tuya-proto/dto"
+	"github.com/rkosegi/tuya-proto/proto"
+)
+
+// plaintextBeaconOffset is position of payload in unencrypted broadcast: header, seq, cmd, length and return code.
+const plaintextBeaconOffset = proto.LenHeaderMark + proto.LenCommonFields + 4
+
+var (
+	// DiscoveryAddrs are addresses devices broadcast to, plaintext beacons (protocol 3.1) are sent to 6666,
+	// encrypted ones (protocol 3.3 and later) to 6667.
+	DiscoveryAddrs = []string{":6666", ":6667"}
+
+	ErrMalformedBeacon = errors.New("malformed beacon")
+)
+
+// DiscoveredDevice is device that announced itself using UDP broadcast.
+type DiscoveredDevice struct {
+	Id         string
+	Ip         string
+	Version    string
+	ProductKey string
+	LastSeen   time.Time
+}
+
+// Protocol returns name of protocol used by device, or empty string if protocol version is not supported.
+func (d DiscoveredDevice) Protocol() string {
+	return protocolForVersion(d.Version)
+}
+
+// Discovery keeps table of devices found by their UDP broadcasts.
+type Discovery struct {
+	l       *slog.Logger
+	lock    sync.RWMutex
+	devices map[string]DiscoveredDevice
+}
+
+// NewDiscovery creates new, empty discovery table.
+func NewDiscovery(l *slog.Logger) *Discovery {
+	return &Discovery{
+		l:       l,
+		devices: map[string]DiscoveredDevice{},
+	}
+}
+
+// DecodeBeacon decodes UDP broadcast datagram, either plaintext or encrypted using well-known UDP key.
+func DecodeBeacon(data []byte) (b *dto.DeviceBeacon34, err error) {
+	defer func() {
+		// malformed packet can make decoder panic
+		if r := recover(); r != nil {
+			b, err = nil, ErrMalformedBeacon
+		}
+	}()
+	if len(data) < plaintextBeaconOffset+proto.LenFooterMark || binary.BigEndian.Uint32(data) != proto.Header31 {
+		return nil, ErrMalformedBeacon
+	}
+	b = &dto.DeviceBeacon34{}
+	// plaintext JSON, followed by CRC and footer
+	if data[plaintextBeaconOffset] == '{' &&
+		json.Unmarshal(data[plaintextBeaconOffset:len(data)-4-proto.LenFooterMark], b) == nil {
+		return b, nil
+	}
+	pkt := proto.Packet{Version: proto.Version31}
+	if err = pkt.Decode(data, proto.UdpKey()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedBeacon, err)
+	}
+	if !pkt.ChecksumValid {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrMalformedBeacon)
+	}
+	if err = json.Unmarshal(pkt.DecryptedPayload, b); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedBeacon, err)
+	}
+	return b, nil
+}
+
+// HandleDatagram decodes single broadcast datagram and records device it announces.
+func (d *Discovery) HandleDatagram(data []byte) error {
+	b, err := DecodeBeacon(data)
+	if err != nil {
+		return err
+	}
+	if b.GwId == "" || b.Ip == "" {
+		return fmt.Errorf("%w: missing device id or address", ErrMalformedBeacon)
+	}
+	dev := DiscoveredDevice{
+		Id:         b.GwId,
+		Ip:         b.Ip,
+		Version:    b.Version,
+		ProductKey: b.ProductKey,
+		LastSeen:   time.Now(),
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if prev, ok := d.devices[dev.Id]; !ok || prev.Ip != dev.Ip || prev.Version != dev.Version {
+		d.l.Debug("device discovered", "id", dev.Id, "ip", dev.Ip, "version", dev.Version)
+	}
+	d.devices[dev.Id] = dev
+	return nil
+}
+
+// Lookup returns last known broadcast of device with given id.
+func (d *Discovery) Lookup(id string) (DiscoveredDevice, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	dev, ok := d.devices[id]
+	return dev, ok
+}
+
+// Devices returns all discovered devices, ordered by id.
+func (d *Discovery) Devices() []DiscoveredDevice {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return slices.SortedFunc(maps.Values(d.devices), func(a, b DiscoveredDevice) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+}
+
+// Listen receives broadcasts on given UDP addresses until context is cancelled.
+func (d *Discovery) Listen(ctx context.Context, addrs ...string) error {
+	var conns []net.PacketConn
+	for _, addr := range addrs {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return err
+		}
+		conns = append(conns, conn)
+	}
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Go(func() {
+			d.receive(conn)
+		})
+	}
+	<-ctx.Done()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (d *Discovery) receive(conn net.PacketConn) {
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				d.l.Warn("discovery listener failed", "address", conn.LocalAddr(), "error", err)
+			}
+			return
+		}
+		if err = d.HandleDatagram(buf[:n]); err != nil {
+			d.l.Debug("ignoring datagram", "from", from, "error", err)
+		}
+	}
+}
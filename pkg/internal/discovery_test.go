@@ -0,0 +1,150 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rkosegi/tuya-proto/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// datagrams captured from real devices
+const (
+	beacon34 = `
+000055aa0000000000000023000000bc
+00000000d09766676f3369eb10b5e9f1
+32fd802a2b8ecdb83424f7a6884b011d
+664ccd46eb00d00863ef3e4e2a06eae3
+f57b018cca322d15a3365719ab56ad0e
+3b4b29067256f992ef0bb3c9946f6ca8
+e2e148532e0dbc9a92c3317286ebf238
+be5797863e4a9d43c5263de269048b7c
+4f281c335cca9cf243b333079bed2b12
+b16ffb346fbdac3e9dd4c59ed6077a37
+13a1f534c5f75cc69c6a0e153160a17b
+c40acc9bf710aae30275845704b34769
+a7f2181a8d9f34800000aa55`
+	beacon33 = `
+000055aa00000000000000130000009c
+00000000d09766676f3369eb10b5e9f1
+32fd802aec706a5a12250180979d960c
+1d67b1f7477a80baeebf1819f050efde
+c4b118da7f045939c571bf14f486bd7a
+2df22af359bd59981c26d5958da187e0
+96d937c840b1058c6d8cd9456f3f7e28
+eed4932b24ce475ef94c0e71baa74939
+a56162db55dd3444d6199d4cf180d10b
+460afda38494c932e298de410f638ed8
+bf10587a88683a8d0000aa55`
+)
+
+func mustFromHex(t *testing.T, s string) []byte {
+	out, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	assert.NoError(t, err)
+	return out
+}
+
+// plaintextBeacon builds unencrypted broadcast, as sent by devices with protocol 3.1.
+func plaintextBeacon(payload string) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, proto.Header31)
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(proto.CmdIdTypeUDP))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(4+len(payload)+4+proto.LenFooterMark))
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	buf = append(buf, payload...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	return binary.BigEndian.AppendUint32(buf, proto.Footer31)
+}
+
+func TestDecodeBeacon(t *testing.T) {
+	b, err := DecodeBeacon(mustFromHex(t, beacon34))
+	assert.NoError(t, err)
+	assert.Equal(t, "bfc4c2312693b32a4eucga", b.GwId)
+	assert.Equal(t, "192.168.1.127", b.Ip)
+	assert.Equal(t, "3.4", b.Version)
+
+	b, err = DecodeBeacon(plaintextBeacon(`{"ip":"192.168.1.5","gwId":"abc","version":"3.1"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", b.GwId)
+	assert.Equal(t, "3.1", b.Version)
+
+	_, err = DecodeBeacon([]byte("hello"))
+	assert.ErrorIs(t, err, ErrMalformedBeacon)
+	data := mustFromHex(t, beacon33)
+	_, err = DecodeBeacon(data[:len(data)-20])
+	assert.ErrorIs(t, err, ErrMalformedBeacon)
+}
+
+func TestDiscovery(t *testing.T) {
+	d := NewDiscovery(slog.New(slog.DiscardHandler))
+	assert.NoError(t, d.HandleDatagram(mustFromHex(t, beacon34)))
+	assert.NoError(t, d.HandleDatagram(mustFromHex(t, beacon33)))
+	assert.NoError(t, d.HandleDatagram(plaintextBeacon(`{"ip":"192.168.1.5","gwId":"abc","version":"3.1"}`)))
+	assert.Error(t, d.HandleDatagram(plaintextBeacon(`{"version":"3.1"}`)))
+
+	devs := d.Devices()
+	assert.Len(t, devs, 3)
+	assert.Equal(t, "abc", devs[0].Id)
+	assert.Equal(t, "tuya3.1", devs[0].Protocol())
+	assert.Equal(t, "", devs[1].Protocol())
+
+	// device moved to other address
+	assert.NoError(t, d.HandleDatagram(plaintextBeacon(`{"ip":"192.168.1.6","gwId":"abc","version":"3.1"}`)))
+	dev, ok := d.Lookup("abc")
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.6", dev.Ip)
+	_, ok = d.Lookup("unknown")
+	assert.False(t, ok)
+}
+
+func TestDiscoveryListen(t *testing.T) {
+	d := NewDiscovery(slog.New(slog.DiscardHandler))
+	ctx, cancel := context.WithCancel(t.Context())
+	// pick free port first
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := pc.LocalAddr().String()
+	_ = pc.Close()
+
+	done := make(chan error)
+	go func() {
+		done <- d.Listen(ctx, addr)
+	}()
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			return false
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		_, _ = conn.Write(mustFromHex(t, beacon34))
+		_, ok := d.Lookup("bfc4c2312693b32a4eucga")
+		return ok
+	}, 5*time.Second, 50*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	OpConnect   = "connect"
+	OpHandshake = "handshake"
+	OpSend      = "send"
+	OpRead      = "read"
+	OpDecode    = "decode"
+)
+
+const (
+	ReasonConnectRefused     = "connect_refused"
+	ReasonConnectTimeout     = "connect_timeout"
+	ReasonAddressUnknown     = "address_unknown"
+	ReasonHandshakeFailed    = "handshake_failed"
+	ReasonBadKey             = "bad_key"
+	ReasonReadTimeout        = "read_timeout"
+	ReasonDecodeError        = "decode_error"
+	ReasonUnexpectedResponse = "unexpected_response"
+)
+
+var (
+	ErrBadKey       = errors.New("unable to decrypt payload, key is probably wrong")
+	ErrShortPayload = errors.New("payload is too short")
+	ErrNoAddress    = errors.New("device address is not known yet")
+	ErrBadDataPoint = errors.New("invalid data point")
+)
+
+// OpError records the client operation during which an error occurred.
+type OpError struct {
+	Op  string
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+func opErr(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OpError{Op: op, Err: err}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
+
+// isPrintable reports whether data looks like text. Payload decrypted using wrong key is just random garbage.
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	return !bytes.ContainsFunc(data, func(r rune) bool {
+		return unicode.IsControl(r) && !unicode.IsSpace(r)
+	})
+}
+
+// ErrorReason maps error returned by Client into one of fixed set of reasons, suitable for use as label value.
+func ErrorReason(err error) string {
+	var oe *OpError
+	if !errors.As(err, &oe) {
+		return ReasonUnexpectedResponse
+	}
+	if errors.Is(err, ErrBadKey) {
+		return ReasonBadKey
+	}
+	switch oe.Op {
+	case OpConnect:
+		if errors.Is(err, ErrNoAddress) {
+			return ReasonAddressUnknown
+		}
+		if isTimeout(err) {
+			return ReasonConnectTimeout
+		}
+		return ReasonConnectRefused
+	case OpHandshake:
+		return ReasonHandshakeFailed
+	case OpRead:
+		if isTimeout(err) {
+			return ReasonReadTimeout
+		}
+	case OpDecode:
+		var se *json.SyntaxError
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &se) || errors.As(err, &te) || errors.Is(err, ErrBadDataPoint) {
+			return ReasonDecodeError
+		}
+	}
+	return ReasonUnexpectedResponse
+}
+
+// IsTransient reports whether error is likely to go away when query is repeated.
+func IsTransient(err error) bool {
+	switch ErrorReason(err) {
+	case ReasonBadKey, ReasonDecodeError:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorReason(t *testing.T) {
+	var jsonErr error
+	jsonErr = json.Unmarshal([]byte("not a json"), &struct{}{})
+	for _, tc := range []struct {
+		err    error
+		reason string
+	}{
+		{opErr(OpConnect, &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), ReasonConnectRefused},
+		{opErr(OpConnect, &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}), ReasonConnectTimeout},
+		{opErr(OpConnect, ErrNoAddress), ReasonAddressUnknown},
+		{opErr(OpHandshake, opErr(OpRead, io.EOF)), ReasonHandshakeFailed},
+		{opErr(OpHandshake, opErr(OpDecode, ErrBadKey)), ReasonBadKey},
+		{opErr(OpRead, os.ErrDeadlineExceeded), ReasonReadTimeout},
+		{opErr(OpRead, io.EOF), ReasonUnexpectedResponse},
+		{opErr(OpDecode, jsonErr), ReasonDecodeError},
+		{opErr(OpDecode, fmt.Errorf("%w 19: missing", ErrBadDataPoint)), ReasonDecodeError},
+		{opErr(OpDecode, ErrShortPayload), ReasonUnexpectedResponse},
+		{errors.New("something else"), ReasonUnexpectedResponse},
+	} {
+		assert.Equal(t, tc.reason, ErrorReason(tc.err), tc.err.Error())
+	}
+}
+
+func TestIsPrintable(t *testing.T) {
+	assert.True(t, isPrintable([]byte(`{"dps":{"1":true}}`)))
+	assert.True(t, isPrintable([]byte("data format error\n")))
+	assert.False(t, isPrintable([]byte{0x01, 0xfe, 0x33, 0x00}))
+}
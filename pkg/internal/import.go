@@ -0,0 +1,223 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+// ImportedDevice is device as listed in devices.json written by tinytuya wizard or in output of tuya-cli wizard.
+type ImportedDevice struct {
+	Name string `yaml:"name"`
+	Id   string `yaml:"id"`
+	Key  string `yaml:"key"`
+	// only known to tinytuya, when device was found on local network
+	Ip      string `yaml:"ip"`
+	Version string `yaml:"version"`
+	// sub-device behind gateway, can't be queried directly
+	Sub bool `yaml:"sub"`
+}
+
+// ImportResult lists names of devices affected by import.
+type ImportResult struct {
+	Added   []string
+	Updated []string
+	// devices which can't be imported, along with reason
+	Skipped []string
+	// devices which address has to be filled in manually
+	MissingAddress []string
+	// devices which key is supplied by other means, such as environment variable or file, and was left as is
+	KeptKeys []string
+}
+
+// ParseImportedDevices parses list of devices from tinytuya devices.json or tuya-cli wizard output.
+// Latter is JavaScript literal rather than JSON, but it's valid YAML flow sequence, so both are parsed the same way.
+func ParseImportedDevices(data []byte) ([]ImportedDevice, error) {
+	var out []ImportedDevice
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("unrecognized format of device list: %w", err)
+	}
+	return out, nil
+}
+
+// SanitizeDeviceName turns name from Tuya app into something suitable as device label value,
+// e.g. "Kitchen Plug #1" becomes "kitchen-plug-1".
+func SanitizeDeviceName(name string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && sb.Len() > 0 {
+				sb.WriteRune('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return sb.String()
+}
+
+// protocolForVersion maps protocol version reported by tinytuya to protocol name, if it's supported.
+func protocolForVersion(version string) string {
+	if p := "tuya" + version; slices.Contains(knownProtocols, p) {
+		return p
+	}
+	return ""
+}
+
+// MergeImportedDevices merges devices into configuration document and returns updated document.
+// Device that is already present (matched by id) gets its key, address and protocol updated,
+// anything else, such as extraLabels, is kept as is. New devices are appended.
+// Document is edited at node level, so that comments and formatting of untouched parts are preserved.
+func MergeImportedDevices(doc []byte, devs []ImportedDevice) ([]byte, *ImportResult, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(doc, &root); err != nil {
+		return nil, nil, err
+	}
+	if root.Kind == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if root.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, errors.New("configuration document is not a mapping")
+	}
+	devices := mappingValue(root.Content[0], "devices")
+	if devices.Tag == "!!null" {
+		*devices = yaml.Node{Kind: yaml.MappingNode}
+	}
+	if devices.Kind != yaml.MappingNode {
+		return nil, nil, errors.New("devices is not a mapping")
+	}
+	byId := map[string]string{}
+	for i := 0; i+1 < len(devices.Content); i += 2 {
+		if id := scalarValue(devices.Content[i+1], "id"); id != "" {
+			byId[id] = devices.Content[i].Value
+		}
+	}
+	res := &ImportResult{}
+	for _, dev := range devs {
+		display := lo.CoalesceOrEmpty(dev.Name, dev.Id)
+		switch {
+		case dev.Sub:
+			res.Skipped = append(res.Skipped, display+" (sub-device)")
+			continue
+		case dev.Id == "" || dev.Key == "":
+			res.Skipped = append(res.Skipped, display+" (missing id or key)")
+			continue
+		}
+		name, exists := byId[dev.Id]
+		if !exists {
+			name = uniqueName(devices, lo.CoalesceOrEmpty(SanitizeDeviceName(dev.Name), dev.Id))
+			byId[dev.Id] = name
+		}
+		spec := mappingValue(devices, name)
+		setScalar(spec, "id", dev.Id)
+		// key might be supplied by other means already, plaintext key must not replace it
+		if hasKey(spec, "keyFile") || hasKey(spec, "keyCommand") || envRefRe.MatchString(scalarValue(spec, "key")) {
+			res.KeptKeys = append(res.KeptKeys, name)
+		} else {
+			setScalar(spec, "key", dev.Key)
+		}
+		if dev.Ip != "" {
+			setScalar(spec, "address", dev.Ip)
+		}
+		if p := protocolForVersion(dev.Version); p != "" {
+			setScalar(spec, "protocol", p)
+		}
+		if !hasKey(spec, "address") {
+			res.MissingAddress = append(res.MissingAddress, name)
+		}
+		if exists {
+			res.Updated = append(res.Updated, name)
+		} else {
+			res.Added = append(res.Added, name)
+		}
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&root); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), res, enc.Close()
+}
+
+// scalarValue returns value of key in mapping node, or empty string if there is no such key.
+func scalarValue(m *yaml.Node, key string) string {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// hasKey reports whether mapping node contains given key.
+func hasKey(m *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingValue returns value of key in mapping node. Missing key is added with empty mapping as value.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	v := &yaml.Node{Kind: yaml.MappingNode}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	return v
+}
+
+// setScalar sets value of key in mapping node to given string.
+func setScalar(m *yaml.Node, key, value string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1].Kind = yaml.ScalarNode
+			m.Content[i+1].Tag = "!!str"
+			m.Content[i+1].Value = value
+			return
+		}
+	}
+	m.Content = append(m.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value},
+	)
+}
+
+// uniqueName returns name that is not yet used as key in mapping node, by adding numeric suffix if needed.
+func uniqueName(m *yaml.Node, name string) string {
+	out := name
+	for i := 2; hasKey(m, out); i++ {
+		out = fmt.Sprintf("%s-%d", name, i)
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSanitizeDeviceName(t *testing.T) {
+	assert.Equal(t, "kitchen-plug-1", SanitizeDeviceName("Kitchen Plug #1"))
+	assert.Equal(t, "obývačka-tv", SanitizeDeviceName("  Obývačka / TV "))
+	assert.Equal(t, "", SanitizeDeviceName("!!!"))
+}
+
+func TestParseImportedDevices(t *testing.T) {
+	// tinytuya devices.json
+	devs, err := ParseImportedDevices([]byte(`[
+  {"name": "Kitchen Plug", "id": "id1", "key": "key1", "mac": "aa:bb:cc:dd:ee:ff", "ip": "192.168.1.5", "version": "3.4"},
+  {"name": "Sensor", "id": "id2", "key": "", "sub": true, "version": 3.3}
+]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []ImportedDevice{
+		{Name: "Kitchen Plug", Id: "id1", Key: "key1", Ip: "192.168.1.5", Version: "3.4"},
+		{Name: "Sensor", Id: "id2", Sub: true, Version: "3.3"},
+	}, devs)
+
+	// tuya-cli wizard
+	devs, err = ParseImportedDevices([]byte(`[
+  {
+    name: 'Office Light',
+    id: 'id3',
+    key: 'key3'
+  }
+]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []ImportedDevice{{Name: "Office Light", Id: "id3", Key: "key3"}}, devs)
+
+	_, err = ParseImportedDevices([]byte(`{"devices": 1}`))
+	assert.Error(t, err)
+}
+
+func TestMergeImportedDevices(t *testing.T) {
+	doc := `# comment is kept
+devices:
+  kitchen:
+    id: id1
+    key: oldkey
+    address: 192.168.1.5
+    extraLabels:
+      room: kitchen
+  secret:
+    id: id4
+    keyFile: secret.key
+  env:
+    id: id6
+    key: ${ENV_PLUG_KEY}
+    address: 192.168.1.6
+  office-light:
+    id: other
+extraDeviceLabels:
+  - room
+`
+	out, res, err := MergeImportedDevices([]byte(doc), []ImportedDevice{
+		{Name: "Kitchen Plug", Id: "id1", Key: "newkey", Ip: "192.168.1.9", Version: "3.4"},
+		{Name: "Office Light", Id: "id3", Key: "key3", Version: "3.3"},
+		{Name: "Secret", Id: "id4", Key: "key4"},
+		{Name: "Sensor", Id: "id2", Sub: true},
+		{Name: "Broken", Id: "id5"},
+		{Name: "Env", Id: "id6", Key: "key6"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"office-light-2"}, res.Added)
+	assert.Equal(t, []string{"kitchen", "secret", "env"}, res.Updated)
+	assert.Equal(t, []string{"secret", "env"}, res.KeptKeys)
+	assert.Equal(t, []string{"Sensor (sub-device)", "Broken (missing id or key)"}, res.Skipped)
+	assert.Equal(t, []string{"office-light-2", "secret"}, res.MissingAddress)
+	assert.Contains(t, string(out), "# comment is kept")
+
+	var cfg ConfigSpec
+	assert.NoError(t, yaml.Unmarshal(out, &cfg))
+	assert.Equal(t, DeviceConnectionSpec{
+		Id:          "id1",
+		Key:         "newkey",
+		Address:     "192.168.1.9",
+		Protocol:    "tuya3.4",
+		ExtraLabels: &map[string]string{"room": "kitchen"},
+	}, cfg.Devices["kitchen"])
+	assert.Equal(t, DeviceConnectionSpec{Id: "id3", Key: "key3"}, cfg.Devices["office-light-2"])
+	assert.Equal(t, "", cfg.Devices["secret"].Key)
+	assert.Equal(t, "${ENV_PLUG_KEY}", cfg.Devices["env"].Key)
+	assert.NotContains(t, string(out), "key6")
+
+	out, res, err = MergeImportedDevices(nil, []ImportedDevice{{Name: "Plug", Id: "id1", Key: "key1", Ip: "10.0.0.1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"plug"}, res.Added)
+	assert.Equal(t, "devices:\n  plug:\n    id: id1\n    key: key1\n    address: 10.0.0.1\n", string(out))
+}
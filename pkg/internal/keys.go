@@ -0,0 +1,213 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	keyLen            = 16
+	keyCommandTimeout = 10 * time.Second
+	redacted          = "<redacted>"
+)
+
+var envRefRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)}`)
+
+// ResolveKeys obtains encryption key of every device and credentials entry from its configured source.
+// References to environment variables in MQTT password and other secrets are expanded as well.
+// Relative paths in keyFile are resolved against baseDir.
+// Errors never contain key itself.
+func (c *ConfigSpec) ResolveKeys(baseDir string) error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(c.Devices)) {
+		dc := c.Devices[name]
+		key, err := resolveKey(dc.Key, dc.KeyFile, dc.KeyCommand, baseDir)
+		if err != nil {
+			errs = append(errs, &PathError{Path: "/devices/" + name, Message: err.Error()})
+			continue
+		}
+		dc.Key = key
+		if dc.Dialer != nil {
+			if dc.Dialer.Socks5Proxy, err = expandEnv(dc.Dialer.Socks5Proxy); err != nil {
+				errs = append(errs, &PathError{Path: "/devices/" + name + "/dialer/socks5Proxy", Message: err.Error()})
+			}
+		}
+		c.Devices[name] = dc
+	}
+	if c.Defaults != nil && c.Defaults.Dialer != nil {
+		proxyUrl, err := expandEnv(c.Defaults.Dialer.Socks5Proxy)
+		if err != nil {
+			errs = append(errs, &PathError{Path: "/defaults/dialer/socks5Proxy", Message: err.Error()})
+		}
+		c.Defaults.Dialer.Socks5Proxy = proxyUrl
+	}
+	if c.Credentials != nil {
+		for _, name := range slices.Sorted(maps.Keys(*c.Credentials)) {
+			cs := (*c.Credentials)[name]
+			key, err := resolveKey(cs.Key, cs.KeyFile, cs.KeyCommand, baseDir)
+			if err != nil {
+				errs = append(errs, &PathError{Path: "/credentials/" + name, Message: err.Error()})
+				continue
+			}
+			cs.Key = key
+			(*c.Credentials)[name] = cs
+		}
+	}
+	if c.Mqtt != nil {
+		password, err := expandEnv(c.Mqtt.Password)
+		if err != nil {
+			errs = append(errs, &PathError{Path: "/mqtt/password", Message: err.Error()})
+		}
+		c.Mqtt.Password = password
+	}
+	if c.Influxdb != nil {
+		token, err := expandEnv(c.Influxdb.Token)
+		if err != nil {
+			errs = append(errs, &PathError{Path: "/influxdb/token", Message: err.Error()})
+		}
+		c.Influxdb.Token = token
+	}
+	if rw := c.RemoteWrite; rw != nil {
+		token, err := expandEnv(rw.BearerToken)
+		if err != nil {
+			errs = append(errs, &PathError{Path: "/remoteWrite/bearerToken", Message: err.Error()})
+		}
+		rw.BearerToken = token
+		if rw.BasicAuth != nil {
+			password, err := expandEnv(rw.BasicAuth.Password)
+			if err != nil {
+				errs = append(errs, &PathError{Path: "/remoteWrite/basicAuth/password", Message: err.Error()})
+			}
+			rw.BasicAuth.Password = password
+		}
+	}
+	if c.Otlp != nil && c.Otlp.Headers != nil {
+		for _, name := range slices.Sorted(maps.Keys(*c.Otlp.Headers)) {
+			value, err := expandEnv((*c.Otlp.Headers)[name])
+			if err != nil {
+				errs = append(errs, &PathError{Path: "/otlp/headers/" + name, Message: err.Error()})
+			}
+			(*c.Otlp.Headers)[name] = value
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolveKey obtains key from exactly one of given sources and checks its length.
+func resolveKey(key string, keyFile *string, keyCommand *[]string, baseDir string) (string, error) {
+	key, err := readKey(key, keyFile, keyCommand, baseDir)
+	if err == nil && len(key) != keyLen {
+		err = fmt.Errorf("key must be %d characters long, got %d", keyLen, len(key))
+	}
+	return key, err
+}
+
+func readKey(key string, keyFile *string, keyCommand *[]string, baseDir string) (string, error) {
+	sources := 0
+	for _, set := range []bool{key != "", keyFile != nil, keyCommand != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return "", errors.New("exactly one of key, keyFile or keyCommand must be set")
+	}
+	switch {
+	case keyFile != nil:
+		path := *keyFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read keyFile: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+
+	case keyCommand != nil:
+		args := *keyCommand
+		ctx, cancel := context.WithTimeout(context.Background(), keyCommandTimeout)
+		defer cancel()
+		// output is intentionally not part of error, it might contain secret
+		out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("keyCommand '%s' failed: %w", args[0], err)
+		}
+		return strings.TrimSpace(string(out)), nil
+
+	default:
+		return expandEnv(key)
+	}
+}
+
+// expandEnv replaces references to environment variables in form of ${NAME} with their values.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	out := envRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRefRe.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable(s) not set: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// LogValue makes sure that encryption key is never logged.
+func (d DeviceConnectionSpec) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", d.Id),
+		slog.String("address", d.Address),
+		slog.String("key", redacted),
+		slog.String("protocol", d.Protocol),
+		slog.String("profile", d.Profile),
+	)
+}
+
+// String makes sure that encryption key is never printed.
+func (d DeviceConnectionSpec) String() string {
+	return fmt.Sprintf("{id=%s address=%s key=%s protocol=%s profile=%s}", d.Id, d.Address, redacted, d.Protocol, d.Profile)
+}
+
+// LogValue makes sure that encryption key is never logged.
+func (c CredentialsSpec) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("key", redacted),
+		slog.String("protocol", c.Protocol),
+	)
+}
+
+// String makes sure that encryption key is never printed.
+func (c CredentialsSpec) String() string {
+	return fmt.Sprintf("{key=%s protocol=%s}", redacted, c.Protocol)
+}
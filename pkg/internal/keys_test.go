@@ -0,0 +1,73 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "0123456789abcdef"
+
+func TestResolveKeys(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "plug.key"), []byte(testKey+"\n"), 0600))
+	t.Setenv("PLUG_KEY", testKey)
+	cfg := &ConfigSpec{
+		Devices: DevicesContainer{
+			"plain":   {Key: testKey},
+			"env":     {Key: "${PLUG_KEY}"},
+			"file":    {KeyFile: new("plug.key")},
+			"command": {KeyCommand: &[]string{"echo", testKey}},
+		},
+	}
+	assert.NoError(t, cfg.ResolveKeys(dir))
+	for name, dc := range cfg.Devices {
+		assert.Equal(t, testKey, dc.Key, name)
+	}
+}
+
+func TestResolveKeysErrors(t *testing.T) {
+	cfg := &ConfigSpec{
+		Devices: DevicesContainer{
+			"none":  {},
+			"both":  {Key: testKey, KeyFile: new("plug.key")},
+			"env":   {Key: "${SURELY_NOT_SET_VARIABLE}"},
+			"short": {Key: "0123"},
+		},
+	}
+	err := cfg.ResolveKeys(t.TempDir())
+	assert.ErrorContains(t, err, "/devices/none: exactly one of key, keyFile or keyCommand must be set")
+	assert.ErrorContains(t, err, "/devices/both: exactly one of key, keyFile or keyCommand must be set")
+	assert.ErrorContains(t, err, "/devices/env: environment variable(s) not set: SURELY_NOT_SET_VARIABLE")
+	assert.ErrorContains(t, err, "/devices/short: key must be 16 characters long, got 4")
+	assert.NotContains(t, err.Error(), "0123")
+}
+
+func TestKeyIsNotLogged(t *testing.T) {
+	dc := DeviceConnectionSpec{Id: "abc", Key: testKey}
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("device", "spec", dc)
+	assert.NotContains(t, buf.String(), testKey)
+	assert.NotContains(t, fmt.Sprintf("%v", dc), testKey)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// label names used by exporter itself
+	reservedLabels = []string{"device", "reason"}
+)
+
+// SanitizeLabelName replaces every character that is not allowed in Prometheus label name with underscore.
+func SanitizeLabelName(name string) string {
+	out := []rune(name)
+	for i, r := range out {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
+			out[i] = '_'
+		}
+	}
+	if len(out) == 0 || (out[0] >= '0' && out[0] <= '9') {
+		return "_" + string(out)
+	}
+	return string(out)
+}
+
+// ExtraLabelNames returns configured extra label names, in order of declaration.
+func (c *ConfigSpec) ExtraLabelNames() []string {
+	if c.ExtraDeviceLabels == nil {
+		return nil
+	}
+	return *c.ExtraDeviceLabels
+}
+
+// ExportedLabelName returns name under which given extra label is exported.
+func (c *ConfigSpec) ExportedLabelName(name string) string {
+	if c.ExtraDeviceLabelsMapping != nil {
+		if out, ok := (*c.ExtraDeviceLabelsMapping)[name]; ok {
+			return out
+		}
+	}
+	return SanitizeLabelName(name)
+}
+
+// ValidateLabels checks that extra labels map to valid and unique Prometheus label names
+// and that every device provides value for each of them.
+func (c *ConfigSpec) ValidateLabels() error {
+	var errs []error
+	seen := map[string]string{}
+	for _, name := range c.ExtraLabelNames() {
+		out := c.ExportedLabelName(name)
+		switch {
+		case !labelNameRe.MatchString(out):
+			errs = append(errs, &PathError{Path: "/extraDeviceLabels",
+				Message: fmt.Sprintf("label '%s' is exported as '%s', which is not valid label name", name, out)})
+		case strings.HasPrefix(out, "__"):
+			errs = append(errs, &PathError{Path: "/extraDeviceLabels",
+				Message: fmt.Sprintf("label '%s' is exported as '%s', names starting with '__' are reserved", name, out)})
+		case slices.Contains(reservedLabels, out):
+			errs = append(errs, &PathError{Path: "/extraDeviceLabels",
+				Message: fmt.Sprintf("label '%s' is exported as '%s', which is used by exporter itself", name, out)})
+		case seen[out] != "":
+			errs = append(errs, &PathError{Path: "/extraDeviceLabels",
+				Message: fmt.Sprintf("labels '%s' and '%s' are both exported as '%s'", seen[out], name, out)})
+		}
+		seen[out] = name
+	}
+	for _, dname := range slices.Sorted(maps.Keys(c.Devices)) {
+		dc := c.Devices[dname]
+		var have map[string]string
+		if dc.ExtraLabels != nil {
+			have = *dc.ExtraLabels
+		}
+		path := "/devices/" + dname + "/extraLabels"
+		for _, name := range c.ExtraLabelNames() {
+			if _, ok := have[name]; !ok {
+				errs = append(errs, &PathError{Path: path, Message: fmt.Sprintf("missing value for label '%s'", name)})
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(have)) {
+			if !slices.Contains(c.ExtraLabelNames(), name) {
+				errs = append(errs, &PathError{Path: path,
+					Message: fmt.Sprintf("unknown label '%s', it must be declared in extraDeviceLabels", name)})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
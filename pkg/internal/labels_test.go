@@ -0,0 +1,53 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeLabelName(t *testing.T) {
+	assert.Equal(t, "tld_acme_myapp_room", SanitizeLabelName("tld.acme.myapp/room"))
+	assert.Equal(t, "_1st_floor", SanitizeLabelName("1st-floor"))
+	assert.Equal(t, "room", SanitizeLabelName("room"))
+}
+
+func TestValidateLabels(t *testing.T) {
+	cfg := &ConfigSpec{
+		ExtraDeviceLabels:        &ExtraDeviceLabels{"tld.acme/room", "tld.acme/phase"},
+		ExtraDeviceLabelsMapping: &map[string]string{"tld.acme/room": "room"},
+		Devices: DevicesContainer{
+			"ok": {ExtraLabels: &map[string]string{"tld.acme/room": "kitchen", "tld.acme/phase": "L1"}},
+		},
+	}
+	assert.NoError(t, cfg.ValidateLabels())
+	assert.Equal(t, "room", cfg.ExportedLabelName("tld.acme/room"))
+	assert.Equal(t, "tld_acme_phase", cfg.ExportedLabelName("tld.acme/phase"))
+
+	cfg.Devices["bad"] = DeviceConnectionSpec{ExtraLabels: &map[string]string{"tld.acme/room": "hall", "floor": "1"}}
+	err := cfg.ValidateLabels()
+	assert.ErrorContains(t, err, "/devices/bad/extraLabels: missing value for label 'tld.acme/phase'")
+	assert.ErrorContains(t, err, "/devices/bad/extraLabels: unknown label 'floor'")
+
+	delete(cfg.Devices, "bad")
+	(*cfg.ExtraDeviceLabelsMapping)["tld.acme/room"] = "tld_acme_phase"
+	assert.ErrorContains(t, cfg.ValidateLabels(), "are both exported as 'tld_acme_phase'")
+	(*cfg.ExtraDeviceLabelsMapping)["tld.acme/room"] = "device"
+	assert.ErrorContains(t, cfg.ValidateLabels(), "used by exporter itself")
+}
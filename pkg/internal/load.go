@@ -0,0 +1,230 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrNoDevices = errors.New("no devices configured")
+
+	configExtensions = []string{".yaml", ".yml", ".json"}
+)
+
+// ConfigFiles expands given paths into list of configuration files.
+// Directory is expanded into files with .yaml, .yml or .json extension it contains, sorted by name.
+func ConfigFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && slices.Contains(configExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// LoadConfig loads configuration from one or more files or directories and merges them into single configuration.
+// Devices are merged across files, any other setting can only be defined once.
+// Once merged, defaults are applied, keys are resolved and configuration is validated.
+// Every problem found is reported along with file where it originates.
+func LoadConfig(paths ...string) (*ConfigSpec, error) {
+	files, err := ConfigFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		cfg ConfigSpec
+		// source file of every device and setting
+		sources = map[string]string{}
+		errs    []error
+	)
+	for _, file := range files {
+		frag, err := loadFragment(file)
+		if err != nil {
+			errs = append(errs, withFile(err, file))
+			continue
+		}
+		errs = append(errs, mergeFragment(&cfg, frag, file, sources))
+	}
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if len(cfg.Devices) == 0 {
+		return nil, ErrNoDevices
+	}
+	cfg.ApplyDefaults()
+	if err = cfg.ResolveKeys(""); err != nil {
+		return nil, attributeErrors(err, sources)
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, attributeErrors(err, sources)
+	}
+	return &cfg, nil
+}
+
+func loadFragment(file string) (*ConfigSpec, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err = ValidateConfig(data); err != nil {
+		return nil, err
+	}
+	var frag ConfigSpec
+	if err = yaml.Unmarshal(data, &frag); err != nil {
+		return nil, err
+	}
+	// relative path to key file is relative to file which references it
+	for name, dc := range frag.Devices {
+		if dc.KeyFile != nil && !filepath.IsAbs(*dc.KeyFile) {
+			dc.KeyFile = new(filepath.Join(filepath.Dir(file), *dc.KeyFile))
+			frag.Devices[name] = dc
+		}
+	}
+	if frag.Credentials != nil {
+		for name, cs := range *frag.Credentials {
+			if cs.KeyFile != nil && !filepath.IsAbs(*cs.KeyFile) {
+				cs.KeyFile = new(filepath.Join(filepath.Dir(file), *cs.KeyFile))
+				(*frag.Credentials)[name] = cs
+			}
+		}
+	}
+	if rw := frag.RemoteWrite; rw != nil {
+		dir := filepath.Dir(file)
+		rw.BearerTokenFile = joinDir(dir, rw.BearerTokenFile)
+		if rw.BasicAuth != nil {
+			rw.BasicAuth.PasswordFile = joinDir(dir, rw.BasicAuth.PasswordFile)
+		}
+		if rw.Tls != nil {
+			rw.Tls.CaFile = joinDir(dir, rw.Tls.CaFile)
+			rw.Tls.CertFile = joinDir(dir, rw.Tls.CertFile)
+			rw.Tls.KeyFile = joinDir(dir, rw.Tls.KeyFile)
+		}
+	}
+	return &frag, nil
+}
+
+// joinDir makes relative path relative to given directory. Empty path is kept.
+func joinDir(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// mergeFragment merges configuration fragment into cfg.
+func mergeFragment(cfg, frag *ConfigSpec, file string, sources map[string]string) error {
+	var errs []error
+	if cfg.Devices == nil {
+		cfg.Devices = DevicesContainer{}
+	}
+	for name, dc := range frag.Devices {
+		path := "/devices/" + name
+		if prev, ok := sources[path]; ok {
+			errs = append(errs, &PathError{File: file, Path: path,
+				Message: fmt.Sprintf("device '%s' is already defined in %s", name, prev)})
+			continue
+		}
+		sources[path] = file
+		cfg.Devices[name] = dc
+	}
+	dst, src := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(frag).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Type().Field(i)
+		if field.Name == "Devices" || src.Field(i).IsZero() {
+			continue
+		}
+		path := "/" + strings.Split(field.Tag.Get("json"), ",")[0]
+		if prev, ok := sources[path]; ok {
+			errs = append(errs, &PathError{File: file, Path: path,
+				Message: fmt.Sprintf("setting is already defined in %s", prev)})
+			continue
+		}
+		sources[path] = file
+		dst.Field(i).Set(src.Field(i))
+	}
+	return errors.Join(errs...)
+}
+
+// withFile annotates every error in (possibly joined) err with file name.
+func withFile(err error, file string) error {
+	var errs []error
+	for _, e := range unwrapJoined(err) {
+		var pe *PathError
+		if errors.As(e, &pe) {
+			errs = append(errs, &PathError{File: file, Path: pe.Path, Message: pe.Message})
+		} else {
+			errs = append(errs, fmt.Errorf("%s: %w", file, e))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// attributeErrors annotates errors of merged configuration with file where offending device or setting comes from.
+func attributeErrors(err error, sources map[string]string) error {
+	var errs []error
+	for _, e := range unwrapJoined(err) {
+		var pe *PathError
+		if errors.As(e, &pe) {
+			out := *pe
+			for path, file := range sources {
+				if out.Path == path || strings.HasPrefix(out.Path, path+"/") {
+					out.File = file
+					break
+				}
+			}
+			e = &out
+		}
+		errs = append(errs, e)
+	}
+	return errors.Join(errs...)
+}
+
+// unwrapJoined splits error created using errors.Join into its parts.
+func unwrapJoined(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []error
+		for _, e := range j.Unwrap() {
+			out = append(out, unwrapJoined(e)...)
+		}
+		return out
+	}
+	return []error{err}
+}
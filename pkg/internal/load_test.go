@@ -0,0 +1,97 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+}
+
+func TestLoadConfigMerge(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "config.yaml"), `
+defaults:
+  protocol: tuya3.4
+  readTimeout: 3s
+`)
+	writeFile(t, filepath.Join(dir, "conf.d", "10-kitchen.yaml"), `
+devices:
+  kitchen:
+    id: abc
+    address: 192.168.1.5
+    keyFile: keys/kitchen.key
+`)
+	writeFile(t, filepath.Join(dir, "conf.d", "20-garage.json"), `{"devices": {"garage": {"id": "def", "address": "192.168.1.6", "key": "`+testKey+`"}}}`)
+	writeFile(t, filepath.Join(dir, "conf.d", "README.md"), "not a configuration")
+	writeFile(t, filepath.Join(dir, "conf.d", "keys", "kitchen.key"), testKey)
+
+	cfg, err := LoadConfig(filepath.Join(dir, "config.yaml"), filepath.Join(dir, "conf.d"))
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Devices, 2)
+	assert.Equal(t, testKey, cfg.Devices["kitchen"].Key)
+	assert.Equal(t, "tuya3.4", cfg.Devices["garage"].Protocol)
+	assert.Equal(t, 3*time.Second, cfg.Devices["garage"].ReadTimeout)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+	writeFile(t, first, `
+minQueryInterval: 10s
+devices:
+  kitchen:
+    id: abc
+    address: 192.168.1.5
+    key: `+testKey)
+	writeFile(t, second, `
+minQueryInterval: 5s
+devices:
+  kitchen:
+    id: def
+    address: 192.168.1.6
+    key: `+testKey)
+
+	_, err := LoadConfig(dir)
+	assert.ErrorContains(t, err, second+": /devices/kitchen: device 'kitchen' is already defined in "+first)
+	assert.ErrorContains(t, err, second+": /minQueryInterval: setting is already defined in "+first)
+
+	writeFile(t, second, `
+devices:
+  garage:
+    id: def
+    address: 192.168.1.6
+    protocol: tuya9.9
+    key: `+testKey)
+	_, err = LoadConfig(dir)
+	assert.ErrorContains(t, err, second+": /devices/garage/protocol")
+
+	_, err = LoadConfig(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+
+	_, err = LoadConfig(t.TempDir())
+	assert.ErrorIs(t, err, ErrNoDevices)
+}
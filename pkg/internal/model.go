@@ -18,15 +18,8 @@ package internal
 
 // TODO move to protocol library ?
 
-type Dps struct {
-	SwitchOn bool `json:"1"`
-	Current  int  `json:"18"`
-	Power    int  `json:"19"`
-	Voltage  int  `json:"20"`
-}
-
 type DpQueryResponse struct {
-	Dps Dps `json:"dps"`
+	Dps map[string]any `json:"dps"`
 }
 
 type DpQueryRequest struct {
@@ -40,3 +33,10 @@ type ProtoStats struct {
 	SentPkts int64
 	SentErrs int64
 }
+
+type DpControlRequest struct {
+	DevId string         `json:"devId"`
+	Uid   string         `json:"uid"`
+	T     string         `json:"t"`
+	Dps   map[string]any `json:"dps"`
+}
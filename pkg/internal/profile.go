@@ -0,0 +1,105 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+const DefaultProfile = "default"
+
+// Profile describes which data points device uses to report its state and how to convert raw values.
+type Profile struct {
+	SwitchDp  string
+	CurrentDp string
+	PowerDp   string
+	VoltageDp string
+	// raw values are divided by these to get Amperes, Watts and Volts
+	CurrentDiv float64
+	PowerDiv   float64
+	VoltageDiv float64
+}
+
+// PlugReading is device state, decoded using Profile.
+type PlugReading struct {
+	SwitchOn bool
+	Current  float64
+	Power    float64
+	Voltage  float64
+}
+
+var profiles = map[string]Profile{
+	DefaultProfile: {
+		SwitchDp: "1", CurrentDp: "18", PowerDp: "19", VoltageDp: "20",
+		CurrentDiv: 1000, PowerDiv: 10, VoltageDiv: 10,
+	},
+}
+
+// LookupProfile finds built-in profile by name. Empty name means default profile.
+func LookupProfile(name string) (Profile, bool) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	p, ok := profiles[name]
+	return p, ok
+}
+
+// ProfileNames returns sorted names of all built-in profiles.
+func ProfileNames() []string {
+	return slices.Sorted(maps.Keys(profiles))
+}
+
+// Decode converts data points into PlugReading.
+// Error wrapping ErrBadDataPoint is returned when any data point of profile is missing or has unexpected type.
+func (p Profile) Decode(dps map[string]any) (PlugReading, error) {
+	var (
+		r   PlugReading
+		err error
+	)
+	if r.SwitchOn, err = dpValue[bool](dps, p.SwitchDp); err != nil {
+		return r, err
+	}
+	if r.Current, err = dpValue[float64](dps, p.CurrentDp); err != nil {
+		return r, err
+	}
+	if r.Power, err = dpValue[float64](dps, p.PowerDp); err != nil {
+		return r, err
+	}
+	if r.Voltage, err = dpValue[float64](dps, p.VoltageDp); err != nil {
+		return r, err
+	}
+	r.Current /= p.CurrentDiv
+	r.Power /= p.PowerDiv
+	r.Voltage /= p.VoltageDiv
+	return r, nil
+}
+
+// dpValue returns value of data point, which must be present and of given type.
+func dpValue[T bool | float64](dps map[string]any, dp string) (T, error) {
+	var zero T
+	v, ok := dps[dp]
+	if !ok {
+		return zero, fmt.Errorf("%w %s: missing", ErrBadDataPoint, dp)
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w %s: expected %T, got %T", ErrBadDataPoint, dp, zero, v)
+	}
+	return t, nil
+}
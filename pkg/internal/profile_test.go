@@ -0,0 +1,39 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	p, ok := LookupProfile("")
+	assert.True(t, ok)
+	r, err := p.Decode(map[string]any{"1": true, "18": 50.0, "19": 100.0, "20": 2300.0, "9": 0.0})
+	assert.NoError(t, err)
+	assert.Equal(t, PlugReading{SwitchOn: true, Current: 0.05, Power: 10, Voltage: 230}, r)
+
+	_, err = p.Decode(map[string]any{"1": true, "18": 50.0, "19": "100", "20": 2300.0})
+	assert.ErrorIs(t, err, ErrBadDataPoint)
+	assert.EqualError(t, err, "invalid data point 19: expected float64, got string")
+	assert.Equal(t, ReasonDecodeError, ErrorReason(opErr(OpDecode, err)))
+
+	_, err = p.Decode(map[string]any{"1": true, "18": 50.0, "19": 100.0})
+	assert.EqualError(t, err, "invalid data point 20: missing")
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// maxScanHostBits limits size of scanned range to 65536 addresses.
+const maxScanHostBits = 16
+
+// ScanOptions controls how range of addresses is scanned.
+type ScanOptions struct {
+	// TCP port to probe, 6668 if empty
+	Port string
+	// number of hosts probed at once
+	Workers int
+	// timeout of connection and of every read and write
+	Timeout time.Duration
+	// settings of connections to hosts within range, such as those of device defaults
+	Dialer *DialerSpec
+	Logger *slog.Logger
+}
+
+// ScanResult describes host which accepted connection on device port.
+type ScanResult struct {
+	Address string `json:"address"`
+	// name of configured device which id and key matched, empty if none did
+	Device   string `json:"device,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	// address of matched device, as configured
+	ConfiguredAddress string `json:"configuredAddress,omitempty"`
+	// whether configured address of matched device differs from address it was found at
+	Stale bool `json:"stale"`
+}
+
+type scanner struct {
+	opts    ScanOptions
+	devices DevicesContainer
+	// resolved hosts of configured addresses, by device name
+	hosts map[string][]string
+	// connects to host within range
+	dial DialFunc
+	// queries device, replaced in tests
+	query func(dc DeviceConnectionSpec) error
+	lock  sync.Mutex
+	// devices which were already found
+	found map[string]bool
+}
+
+// scanHosts returns addresses of hosts within range. Network and broadcast addresses of IPv4 ranges are excluded.
+func scanHosts(prefix netip.Prefix) ([]netip.Addr, error) {
+	prefix = prefix.Masked()
+	bits := prefix.Addr().BitLen() - prefix.Bits()
+	if bits > maxScanHostBits {
+		return nil, fmt.Errorf("range %s is too large, at most /%d is allowed", prefix, prefix.Addr().BitLen()-maxScanHostBits)
+	}
+	var hosts []netip.Addr
+	for a := prefix.Addr(); a.IsValid() && prefix.Contains(a); a = a.Next() {
+		hosts = append(hosts, a)
+	}
+	if prefix.Addr().Is4() && bits >= 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// Scan probes device port of every host within range using bounded number of workers.
+// Configured ids and keys are tried with every protocol version against each host that accepts connection,
+// to find out which configured device it is.
+func Scan(ctx context.Context, prefix netip.Prefix, devices DevicesContainer, opts ScanOptions) ([]ScanResult, error) {
+	hosts, err := scanHosts(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newScanner(devices, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.resolveConfigured(ctx)
+	return s.run(ctx, hosts), nil
+}
+
+func newScanner(devices DevicesContainer, opts ScanOptions) (*scanner, error) {
+	dial, err := NewDialer(opts.Dialer, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	opts.Port = lo.CoalesceOrEmpty(opts.Port, defaultDevicePort)
+	opts.Workers = max(opts.Workers, 1)
+	opts.Logger = lo.CoalesceOrEmpty(opts.Logger, slog.Default())
+	s := &scanner{
+		opts:    opts,
+		devices: devices,
+		hosts:   map[string][]string{},
+		dial:    dial,
+		found:   map[string]bool{},
+	}
+	s.query = func(dc DeviceConnectionSpec) error {
+		_, err := QueryDps(NewDeviceClient(dc, WithLogger(opts.Logger.With("address", dc.Address, "protocol", dc.Protocol))), dc)
+		return err
+	}
+	return s, nil
+}
+
+// resolveConfigured resolves configured addresses of devices, so that they can be compared with addresses found.
+func (s *scanner) resolveConfigured(ctx context.Context) {
+	for name, dc := range s.devices {
+		if dc.Address == "" {
+			continue
+		}
+		host, _, err := net.SplitHostPort(dc.Address)
+		if err != nil {
+			host = dc.Address
+		}
+		rctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+		addrs, err := net.DefaultResolver.LookupHost(rctx, host)
+		cancel()
+		if err != nil {
+			s.opts.Logger.Warn("unable to resolve configured address", "device", name, "address", dc.Address, "error", err)
+			addrs = []string{host}
+		}
+		s.hosts[name] = addrs
+	}
+}
+
+func (s *scanner) run(ctx context.Context, hosts []netip.Addr) []ScanResult {
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		results []ScanResult
+	)
+	ch := make(chan netip.Addr)
+	for range s.opts.Workers {
+		wg.Go(func() {
+			for a := range ch {
+				if r, ok := s.probe(ctx, a.String()); ok {
+					lock.Lock()
+					results = append(results, r)
+					lock.Unlock()
+				}
+			}
+		})
+	}
+	for _, a := range hosts {
+		if ctx.Err() != nil {
+			break
+		}
+		ch <- a
+	}
+	close(ch)
+	wg.Wait()
+	slices.SortFunc(results, func(a, b ScanResult) int {
+		return netip.MustParseAddr(a.Address).Compare(netip.MustParseAddr(b.Address))
+	})
+	return results
+}
+
+// candidates returns names of devices to try against host. Devices configured with this address go first.
+func (s *scanner) candidates(ip string) []string {
+	var first, rest []string
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, name := range slices.Sorted(maps.Keys(s.devices)) {
+		// payload can't be encrypted using key of wrong size
+		if s.found[name] || len(s.devices[name].Key) != keyLen {
+			continue
+		}
+		if slices.Contains(s.hosts[name], ip) {
+			first = append(first, name)
+		} else {
+			rest = append(rest, name)
+		}
+	}
+	return append(first, rest...)
+}
+
+// probe checks whether host accepts connections on device port and if so, which configured device it is.
+func (s *scanner) probe(ctx context.Context, ip string) (ScanResult, bool) {
+	addr := net.JoinHostPort(ip, s.opts.Port)
+	conn, err := s.dial(ctx, "tcp", addr)
+	if err != nil {
+		return ScanResult{}, false
+	}
+	_ = conn.Close()
+	s.opts.Logger.Debug("host accepts connections", "address", addr)
+	r := ScanResult{Address: ip}
+	for _, name := range s.candidates(ip) {
+		dc := s.devices[name]
+		// configured protocol is tried first
+		protocols := append([]string{dc.Protocol}, slices.DeleteFunc(slices.Clone(knownProtocols), func(p string) bool {
+			return p == dc.Protocol
+		})...)
+		for _, p := range protocols {
+			if ctx.Err() != nil {
+				return r, true
+			}
+			pdc := dc
+			pdc.Address = addr
+			pdc.Protocol = p
+			pdc.ConnectTimeout = s.opts.Timeout
+			pdc.ReadTimeout = s.opts.Timeout
+			pdc.WriteTimeout = s.opts.Timeout
+			if err = s.query(pdc); err != nil {
+				s.opts.Logger.Debug("device does not match", "address", addr, "device", name, "protocol", p, "error", err)
+				continue
+			}
+			s.lock.Lock()
+			s.found[name] = true
+			s.lock.Unlock()
+			r.Device = name
+			r.Protocol = p
+			r.ConfiguredAddress = dc.Address
+			r.Stale = dc.Address != "" && !slices.Contains(s.hosts[name], ip)
+			return r, true
+		}
+	}
+	return r, true
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanHosts(t *testing.T) {
+	hosts, err := scanHosts(netip.MustParsePrefix("192.168.1.5/30"))
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.1.5"), netip.MustParseAddr("192.168.1.6")}, hosts)
+	hosts, err = scanHosts(netip.MustParsePrefix("192.168.1.5/32"))
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 1)
+	_, err = scanHosts(netip.MustParsePrefix("10.0.0.0/8"))
+	assert.ErrorContains(t, err, "too large")
+}
+
+func TestScan(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = ln.Close()
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	s, err := newScanner(DevicesContainer{
+		"plug-1": {Address: "127.0.0.1", Id: "a", Key: "0123456789abcdef", Protocol: "tuya3.1"},
+		"plug-2": {Address: "127.0.0.7:6668", Id: "b", Key: "fedcba9876543210", Protocol: "tuya3.1"},
+		"plug-3": {Id: "c", Key: "short", Protocol: "tuya3.1"},
+	}, ScanOptions{Port: port, Workers: 4, Timeout: time.Second, Logger: slog.New(slog.DiscardHandler)})
+	assert.NoError(t, err)
+	s.resolveConfigured(t.Context())
+	var (
+		lock  sync.Mutex
+		tried []string
+	)
+	s.query = func(dc DeviceConnectionSpec) error {
+		lock.Lock()
+		defer lock.Unlock()
+		tried = append(tried, dc.Id+"/"+dc.Protocol)
+		// device moved from its configured address and uses newer protocol than configured
+		if dc.Id == "b" && dc.Protocol == "tuya3.4" {
+			return nil
+		}
+		return errors.New("no match")
+	}
+	res := s.run(t.Context(), []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("127.0.0.2")})
+	assert.Equal(t, []ScanResult{{
+		Address:           "127.0.0.1",
+		Device:            "plug-2",
+		Protocol:          "tuya3.4",
+		ConfiguredAddress: "127.0.0.7:6668",
+		Stale:             true,
+	}}, res)
+	// device configured at this address is tried first, configured protocol first, key of wrong size is never tried
+	assert.Equal(t, []string{"a/tuya3.1", "a/tuya3.4", "b/tuya3.1", "b/tuya3.4"}, tried)
+}
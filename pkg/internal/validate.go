@@ -0,0 +1,103 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"gopkg.in/yaml.v3"
+
+	schema "github.com/rkosegi/tuya-smartplug-exporter"
+)
+
+const schemaUrl = "https://github.com/rkosegi/tuya-smartplug-exporter/config.schema.v1"
+
+var compiledSchema = sync.OnceValues(func() (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema.ConfigV1))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	if err = c.AddResource(schemaUrl, doc); err != nil {
+		return nil, err
+	}
+	return c.Compile(schemaUrl)
+})
+
+// PathError is single validation problem found in configuration document.
+type PathError struct {
+	// File where problem was found, might be empty
+	File string
+	// JSON pointer to offending value, such as /devices/plug-1/connectTimeout
+	Path    string
+	Message string
+}
+
+func (e *PathError) Error() string {
+	if e.File != "" {
+		return e.File + ": " + e.Path + ": " + e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidateConfig validates YAML (or JSON) configuration document against embedded JSON schema.
+// All problems found are reported, each one as *PathError joined using errors.Join.
+func ValidateConfig(data []byte) error {
+	sch, err := compiledSchema()
+	if err != nil {
+		return fmt.Errorf("unable to compile schema: %w", err)
+	}
+	var doc any
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	// round-trip through JSON to get types that schema validator understands
+	if data, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	if doc, err = jsonschema.UnmarshalJSON(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	err = sch.Validate(doc)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+	var errs []error
+	collectLeafErrors(ve, &errs)
+	return errors.Join(errs...)
+}
+
+func collectLeafErrors(ve *jsonschema.ValidationError, errs *[]error) {
+	if len(ve.Causes) == 0 {
+		pe := &PathError{Path: "/" + strings.Join(ve.InstanceLocation, "/")}
+		if out := ve.BasicOutput(); out.Error != nil {
+			pe.Message = out.Error.String()
+		}
+		*errs = append(*errs, pe)
+		return
+	}
+	for _, c := range ve.Causes {
+		collectLeafErrors(c, errs)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2026 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSampleConfig(t *testing.T) {
+	data, err := os.ReadFile("../../config.yaml")
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateConfig(data))
+}
+
+func TestValidateConfigReportsAllErrors(t *testing.T) {
+	err := ValidateConfig([]byte(`
+devices:
+  plug-1:
+    key: 0123456789abcdef
+    address: 192.168.1.5
+    protocol: tuya3.4
+    readTimeout: 3 seconds
+    color: red
+`))
+	assert.Error(t, err)
+	var paths []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var pe *PathError
+		assert.True(t, errors.As(e, &pe))
+		paths = append(paths, pe.Path)
+	}
+	assert.ElementsMatch(t, []string{"/devices/plug-1", "/devices/plug-1", "/devices/plug-1/readTimeout"}, paths)
+}